@@ -6,22 +6,111 @@ import (
 	"errors"
 )
 
+// Client certificate modes of the API listener
+const (
+	CLIENT_AUTH_NONE    = "none"
+	CLIENT_AUTH_REQUEST = "request"
+	CLIENT_AUTH_REQUIRE = "require"
+)
+
+// Certificate storage backends
+const (
+	DB_DRIVER_MONGO    = "mongo"
+	DB_DRIVER_MEMORY   = "memory"
+	DB_DRIVER_SQLITE   = "sqlite"
+	DB_DRIVER_POSTGRES = "postgres"
+	DB_DRIVER_BOLT     = "bolt"
+)
+
+type SignerConfig struct {
+	Backend          string `json:"backend"`
+	PassphraseSource string `json:"passphrase_source"`
+	PassphraseEnv    string `json:"passphrase_env"`
+	PassphraseFd     int    `json:"passphrase_fd"`
+	SocketPath       string `json:"socket_path"`
+	SocketTimeout    int    `json:"socket_timeout"`
+}
+
 type Config struct {
 	DbConfig struct {
-		Host string `json:"host"`
-		Port int    `json:"port"`
-		Name string `json:"name"`
+		Driver string `json:"driver"`
+		Host   string `json:"host"`
+		Port   int    `json:"port"`
+		Name   string `json:"name"`
+		Dsn    string `json:"dsn"`
+		// Uri overrides host, port and name of mongo, e.g. for credentials and replica sets
+		Uri         string `json:"uri"`
+		Timeout     int    `json:"timeout"`
+		MaxPoolSize uint64 `json:"max_pool_size"`
 	} `json:"db_config"`
 	HttpConfig struct {
 		Listen         string `json:"listen"`
 		Port           int    `json:"port"`
 		SSLCertPath    string `json:"ssl_cert_path"`
 		SSLCertKeyPath string `json:"ssl_cert_key_path"`
+		ClientAuth     string `json:"client_auth"`
 	} `json:"http_config"`
-	RootCertPath    string `json:"root_cert_path"`
-	RootCertKeyPath string `json:"root_cert_private_key_path"`
-	CertTTL         int    `json:"cert_ttl"`
-	KeyRSABits      int    `json:"key_rsa_bits"`
+	RootCertPath    string       `json:"root_cert_path"`
+	RootCertKeyPath string       `json:"root_cert_private_key_path"`
+	RootKey         SignerConfig `json:"root_key"`
+	TrustedRoots    []struct {
+		CertPath    string `json:"cert_path"`
+		RetireAfter string `json:"retire_after"`
+	} `json:"trusted_roots"`
+	CrossCertPaths []string `json:"cross_cert_paths"`
+	IssuingCAs     []struct {
+		CertPath  string       `json:"cert_path"`
+		ChainPath string       `json:"chain_path"`
+		KeyPath   string       `json:"key_path"`
+		Key       SignerConfig `json:"key"`
+		Active    bool         `json:"active"`
+	} `json:"issuing_cas"`
+	CertTTL      int    `json:"cert_ttl"`
+	KeyRSABits   int    `json:"key_rsa_bits"`
+	KeyAlgorithm string `json:"key_algorithm"`
+	SanAllowlist struct {
+		DNS   []string `json:"dns"`
+		IP    []string `json:"ip"`
+		URI   []string `json:"uri"`
+		Email []string `json:"email"`
+	} `json:"san_allowlist"`
+	Profiles map[string]struct {
+		KeyUsage        []string `json:"key_usage"`
+		ExtKeyUsage     []string `json:"ext_key_usage"`
+		IsCA            bool     `json:"is_ca"`
+		MaxPathLen      int      `json:"max_path_len"`
+		MaxTTL          int      `json:"max_ttl"`
+		AllowedSanTypes []string `json:"allowed_san_types"`
+	} `json:"profiles"`
+	DefaultProfile string `json:"default_profile"`
+	Pkcs12Legacy   bool   `json:"pkcs12_legacy"`
+	KeyEncryption  struct {
+		KDF              string `json:"kdf"`
+		PBKDF2Iterations int    `json:"pbkdf2_iterations"`
+		ScryptCost       int    `json:"scrypt_cost"`
+		LegacyPEM        bool   `json:"legacy_pem"`
+	} `json:"key_encryption"`
+	IdentityOid string `json:"identity_oid"`
+	AdminToken  string `json:"admin_token"`
+	CRLConfig   struct {
+		URL        string `json:"url"`
+		Schedule   string `json:"schedule"`
+		NextUpdate int    `json:"next_update"`
+	} `json:"crl_config"`
+	OCSPConfig struct {
+		URL               string       `json:"url"`
+		NextUpdate        int          `json:"next_update"`
+		ResponderCertPath string       `json:"responder_cert_path"`
+		ResponderKeyPath  string       `json:"responder_key_path"`
+		ResponderKey      SignerConfig `json:"responder_key"`
+		ResponderTTL      int          `json:"responder_ttl"`
+	} `json:"ocsp_config"`
+	ChallengeConfig struct {
+		TTL            int `json:"ttl"`
+		MaxOutstanding int `json:"max_outstanding"`
+		MaxPerIdentity int `json:"max_per_identity"`
+		MaxPerClient   int `json:"max_per_client"`
+	} `json:"challenge_config"`
 	CertificateSubject struct {
 		CommonName         string `json:"common_name"`
 		Country            string `json:"country"`
@@ -83,25 +172,61 @@ func FindConfig() (string, error) {
 func NewConfig() *Config {
 	return &Config{
 		DbConfig: struct {
-			Host string `json:"host"`
-			Port int    `json:"port"`
-			Name string `json:"name"`
-		}{Host: "localhost", Port: 27017, Name: "crtmgr"},
+			Driver string `json:"driver"`
+			Host   string `json:"host"`
+			Port   int    `json:"port"`
+			Name   string `json:"name"`
+			Dsn    string `json:"dsn"`
+			// Uri overrides host, port and name of mongo, e.g. for credentials and replica sets
+			Uri         string `json:"uri"`
+			Timeout     int    `json:"timeout"`
+			MaxPoolSize uint64 `json:"max_pool_size"`
+		}{Driver: DB_DRIVER_MONGO, Host: "localhost", Port: 27017, Name: "crtmgr", Timeout: 10, MaxPoolSize: 100},
 		HttpConfig: struct {
 			Listen         string `json:"listen"`
 			Port           int    `json:"port"`
 			SSLCertPath    string `json:"ssl_cert_path"`
 			SSLCertKeyPath string `json:"ssl_cert_key_path"`
+			ClientAuth     string `json:"client_auth"`
 		}{
 			Listen:         "",
 			Port:           443,
 			SSLCertPath:    "server.crt",
 			SSLCertKeyPath: "server.key",
+			ClientAuth:     CLIENT_AUTH_NONE,
 		},
 		RootCertPath:    "root.crt",
 		RootCertKeyPath: "root.key",
-		CertTTL:         30,
-		KeyRSABits:      2048,
+		RootKey: SignerConfig{
+			Backend:          "file",
+			PassphraseSource: "env",
+			PassphraseEnv:    "CRTMGR_ROOT_KEY_PASSPHRASE",
+			PassphraseFd:     3,
+			SocketTimeout:    10,
+		},
+		CertTTL:        30,
+		KeyRSABits:     2048,
+		KeyAlgorithm:   "rsa",
+		DefaultProfile: "leaf",
+		CRLConfig: struct {
+			URL        string `json:"url"`
+			Schedule   string `json:"schedule"`
+			NextUpdate int    `json:"next_update"`
+		}{Schedule: "0 * * * *", NextUpdate: 24},
+		OCSPConfig: struct {
+			URL               string       `json:"url"`
+			NextUpdate        int          `json:"next_update"`
+			ResponderCertPath string       `json:"responder_cert_path"`
+			ResponderKeyPath  string       `json:"responder_key_path"`
+			ResponderKey      SignerConfig `json:"responder_key"`
+			ResponderTTL      int          `json:"responder_ttl"`
+		}{NextUpdate: 60, ResponderTTL: 7},
+		ChallengeConfig: struct {
+			TTL            int `json:"ttl"`
+			MaxOutstanding int `json:"max_outstanding"`
+			MaxPerIdentity int `json:"max_per_identity"`
+			MaxPerClient   int `json:"max_per_client"`
+		}{TTL: 60, MaxOutstanding: 10000, MaxPerIdentity: 5, MaxPerClient: 20},
 		CertificateSubject: struct {
 			CommonName         string `json:"common_name"`
 			Country            string `json:"country"`
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kuai6/nc-crtmgr/src/signer"
+	"golang.org/x/term"
+)
+
+const (
+	PASSPHRASE_SOURCE_ENV    = "env"
+	PASSPHRASE_SOURCE_FD     = "fd"
+	PASSPHRASE_SOURCE_PROMPT = "prompt"
+)
+
+// loadSigner creates the signer of a CA private key with the configured backend
+func loadSigner(config SignerConfig, path string) (crypto.Signer, error) {
+	switch config.Backend {
+	case signer.BACKEND_NONE:
+		return nil, nil
+	case "", signer.BACKEND_FILE:
+		return signer.NewFileSigner(path)
+	case signer.BACKEND_ENCRYPTED_FILE:
+		passphrase, err := readPassphrase(config, path)
+		if err != nil {
+			return nil, err
+		}
+		return signer.NewEncryptedFileSigner(path, passphrase)
+	case signer.BACKEND_SOCKET:
+		return signer.NewSocketSigner(config.SocketPath, time.Duration(config.SocketTimeout)*time.Second)
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown signer backend %s", config.Backend))
+}
+
+// readPassphrase reads the password of the private key from the configured source
+func readPassphrase(config SignerConfig, path string) ([]byte, error) {
+	switch config.PassphraseSource {
+	case "", PASSPHRASE_SOURCE_ENV:
+		passphrase := os.Getenv(config.PassphraseEnv)
+		// do not leak the passphrase to child processes
+		os.Unsetenv(config.PassphraseEnv)
+		if passphrase == "" {
+			return nil, errors.New(fmt.Sprintf("Passphrase for %s is not set in %s environment variable", path, config.PassphraseEnv))
+		}
+		return []byte(passphrase), nil
+	case PASSPHRASE_SOURCE_FD:
+		f := os.NewFile(uintptr(config.PassphraseFd), "passphrase")
+		if f == nil {
+			return nil, errors.New(fmt.Sprintf("Invalid passphrase file descriptor %d", config.PassphraseFd))
+		}
+		defer f.Close()
+		line, err := bufio.NewReader(f).ReadString('\n')
+		if err != nil && line == "" {
+			return nil, errors.New(fmt.Sprintf("Can't read passphrase from file descriptor %d: %s", config.PassphraseFd, err))
+		}
+		return []byte(strings.TrimRight(line, "\r\n")), nil
+	case PASSPHRASE_SOURCE_PROMPT:
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return nil, errors.New("Can't prompt for passphrase, stdin is not a terminal")
+		}
+		fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", path)
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Can't read passphrase: %s", err))
+		}
+		return passphrase, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown passphrase source %s", config.PassphraseSource))
+}
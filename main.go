@@ -1,6 +1,7 @@
 package main
 
 import (
+	"net"
 	"net/http"
 	"encoding/json"
 	"io/ioutil"
@@ -8,42 +9,91 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/kuai6/nc-crtmgr/src/mongo"
+	"github.com/kuai6/nc-crtmgr/src/memory"
+	"github.com/kuai6/nc-crtmgr/src/boltdb"
+	"github.com/kuai6/nc-crtmgr/src/sqldb"
+	"github.com/kuai6/nc-crtmgr/src/certificate"
 	"github.com/kuai6/nc-crtmgr/src/generator"
 	"github.com/kuai6/nc-crtmgr/src/service"
 	"github.com/mileusna/crontab"
 	"github.com/sarulabs/di"
-	"gopkg.in/mgo.v2"
+	"golang.org/x/crypto/ocsp"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
 	"flag"
 	"encoding/base64"
 	"time"
+	"strings"
+	"errors"
+	"os"
+	"io"
+	"net/url"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/pem"
 )
 
 var (
 	cliConfigFilePath = flag.String("config", "", "Config file path")
+	cliCrossSign      = flag.String("cross-sign", "", "Root certificate path to cross sign with the active root, the result is written to stdout")
 )
 
+type SubjectAltName struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
 type GenerateRequest struct {
-	Uid       string `json:"uid"`
-	Did       string `json:"did"`
-	Password  string `json:"password"`
-	ValidFrom string `json:"valid_from"`
-	ValidFor  string `json:"valid_for"`
+	Uid          string           `json:"uid"`
+	Did          string           `json:"did"`
+	Password     string           `json:"password"`
+	ValidFrom    string           `json:"valid_from"`
+	ValidFor     string           `json:"valid_for"`
+	KeyAlgorithm string           `json:"key_algorithm"`
+	Sans         []SubjectAltName `json:"sans"`
+	Profile      string           `json:"profile"`
+	Format       string           `json:"format"`
 }
 
 type GenerateResponse struct {
-	Uid         string `json:"uid"`
-	Did         string `json:"did"`
-	Certificate string `json:"certificate"`
-	PrivateKey  string `json:"private_key"`
-	ValidTill   string `json:"valid_till"`
-	Result      bool   `json:"result"`
-	Reason      string `json:"reason"`
+	Uid         string           `json:"uid"`
+	Did         string           `json:"did"`
+	Certificate string           `json:"certificate"`
+	PrivateKey  string           `json:"private_key"`
+	ValidTill   string           `json:"valid_till"`
+	Sans        []SubjectAltName `json:"sans"`
+	Chain       []string         `json:"chain"`
+	Pkcs12      string           `json:"pkcs12,omitempty"`
+	Result      bool             `json:"result"`
+	Reason      string           `json:"reason"`
+}
+
+type SignRequest struct {
+	Uid       string           `json:"uid"`
+	Did       string           `json:"did"`
+	Csr       string           `json:"csr"`
+	ValidFrom string           `json:"valid_from"`
+	ValidFor  string           `json:"valid_for"`
+	Sans      []SubjectAltName `json:"sans"`
+	Profile   string           `json:"profile"`
+}
+
+type SignResponse struct {
+	Uid         string           `json:"uid"`
+	Did         string           `json:"did"`
+	Certificate string           `json:"certificate"`
+	ValidTill   string           `json:"valid_till"`
+	Sans        []SubjectAltName `json:"sans"`
+	Chain       []string         `json:"chain"`
+	Result      bool             `json:"result"`
+	Reason      string           `json:"reason"`
 }
 
 type ValidateRequest struct {
 	Uid         string `json:"uid"`
 	Did         string `json:"did"`
 	Certificate string `json:"certificate"`
+	// At is the optional RFC 3339 moment to validate the certificate for, now by default
+	At string `json:"at"`
 }
 
 type ValidateResponse struct {
@@ -51,31 +101,142 @@ type ValidateResponse struct {
 	Did         string `json:"did"`
 	Result      bool   `json:"result"`
 	Reason		string `json:"reason"`
+	Code        string `json:"code,omitempty"`
+	Report      *ValidationReport `json:"report,omitempty"`
 }
 
-type ValidateRequestWithNewCertificate struct {
+type ValidationCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type ValidationReport struct {
+	Subject           string            `json:"subject"`
+	Issuer            string            `json:"issuer"`
+	Serial            string            `json:"serial"`
+	FingerprintSHA256 string            `json:"fingerprint_sha256"`
+	FingerprintSHA1   string            `json:"fingerprint_sha1"`
+	NotBefore         string            `json:"not_before"`
+	NotAfter          string            `json:"not_after"`
+	Uid               string            `json:"uid"`
+	Did               string            `json:"did"`
+	Sans              []SubjectAltName  `json:"sans"`
+	Chain             []string          `json:"chain"`
+	Status            string            `json:"status"`
+	At                string            `json:"at"`
+	Checks            []ValidationCheck `json:"checks"`
+}
+
+type ChallengeRequest struct {
+	Uid string `json:"uid"`
+	Did string `json:"did"`
+}
+
+type ChallengeResponse struct {
+	Uid       string `json:"uid"`
+	Did       string `json:"did"`
+	Challenge string `json:"challenge"`
+	ExpiresAt string `json:"expires_at"`
+	Result    bool   `json:"result"`
+	Reason    string `json:"reason"`
+}
+
+type ValidateProofRequest struct {
 	Uid         string `json:"uid"`
 	Did         string `json:"did"`
 	Certificate string `json:"certificate"`
-	Password    string `json:"password"`
-	ValidFrom   string `json:"valid_from"`
-	ValidFor    string `json:"valid_for"`
+	Challenge   string `json:"challenge"`
+	Signature   string `json:"signature"`
+}
+
+type ValidateRequestWithNewCertificate struct {
+	Uid          string           `json:"uid"`
+	Did          string           `json:"did"`
+	Certificate  string           `json:"certificate"`
+	Password     string           `json:"password"`
+	ValidFrom    string           `json:"valid_from"`
+	ValidFor     string           `json:"valid_for"`
+	KeyAlgorithm string           `json:"key_algorithm"`
+	Sans         []SubjectAltName `json:"sans"`
+	Profile      string           `json:"profile"`
+	Format       string           `json:"format"`
 }
 
 type ValidateResponseWithNewCertificate struct {
-	Uid         string `json:"uid"`
-	Did         string `json:"did"`
-	Certificate string `json:"certificate"`
-	PrivateKey  string `json:"private_key"`
-	ValidTill   string `json:"valid_till"`
-	Result      bool   `json:"result"`
-	Reason      string `json:"reason"`
+	Uid         string           `json:"uid"`
+	Did         string           `json:"did"`
+	Certificate string           `json:"certificate"`
+	PrivateKey  string           `json:"private_key"`
+	ValidTill   string           `json:"valid_till"`
+	Sans        []SubjectAltName `json:"sans"`
+	Chain       []string         `json:"chain"`
+	Pkcs12      string           `json:"pkcs12,omitempty"`
+	Result      bool             `json:"result"`
+	Reason      string           `json:"reason"`
+	Code        string           `json:"code,omitempty"`
 }
 
 type WithdrawalRequest struct {
+	Uid            string `json:"uid"`
+	Did            string `json:"did"`
+	Certificate    string `json:"certificate"`
+	Reason         string `json:"reason"`
+	Comment        string `json:"comment"`
+	InvalidityDate string `json:"invalidity_date"`
+	Actor          string `json:"actor"`
+}
+
+type AdminRevokeRequest struct {
+	Serial         string `json:"serial"`
+	Uid            string `json:"uid"`
+	Did            string `json:"did"`
+	Reason         string `json:"reason"`
+	Comment        string `json:"comment"`
+	InvalidityDate string `json:"invalidity_date"`
+	Actor          string `json:"actor"`
+}
+
+type AdminRevokeResponse struct {
+	Serials []string `json:"serials"`
+	Result  bool     `json:"result"`
+	Reason  string   `json:"reason"`
+}
+
+type SuspendRequest struct {
 	Uid         string `json:"uid"`
 	Did         string `json:"did"`
 	Certificate string `json:"certificate"`
+	Comment     string `json:"comment"`
+	Actor       string `json:"actor"`
+}
+
+type SuspendResponse struct {
+	Uid    string `json:"uid"`
+	Did    string `json:"did"`
+	Result bool   `json:"result"`
+	Reason string `json:"reason"`
+}
+
+type RevocationInfo struct {
+	Reason         string `json:"reason"`
+	Comment        string `json:"comment"`
+	InvalidityDate string `json:"invalidity_date,omitempty"`
+	RevokedBy      string `json:"revoked_by"`
+	RevokedAt      string `json:"revoked_at"`
+}
+
+type CertificateResponse struct {
+	Serial      string          `json:"serial"`
+	Uid         string          `json:"uid"`
+	Did         string          `json:"did"`
+	Certificate string          `json:"certificate"`
+	Status      string          `json:"status"`
+	ValidTill   string          `json:"valid_till"`
+	Revocation  *RevocationInfo `json:"revocation,omitempty"`
+	Result      bool            `json:"result"`
+	Reason      string          `json:"reason"`
 }
 
 type WithdrawalResponse struct {
@@ -113,14 +274,44 @@ func main() {
 		Scope: di.App,
 		Build: func(ctx di.Context) (interface{}, error) {
 			config := ctx.Get("config").(*Config)
-			mongoDsn := fmt.Sprintf("mongodb://%s:%d/%s", config.DbConfig.Host, config.DbConfig.Port, config.DbConfig.Name)
-			session, err := mgo.Dial(mongoDsn)
+			mongoDsn := config.DbConfig.Uri
+			if mongoDsn == "" {
+				mongoDsn = fmt.Sprintf("mongodb://%s:%d/%s", config.DbConfig.Host, config.DbConfig.Port, config.DbConfig.Name)
+			}
+			client, err := mongo.Connect(mongoDsn, config.DbConfig.MaxPoolSize, time.Duration(config.DbConfig.Timeout)*time.Second)
 			if err != nil {
 				logger.Critical(err)
+				return nil, err
+			}
+			return client, nil
+		},
+	})
+
+	builder.AddDefinition(di.Definition{
+		Name:  "repository",
+		Scope: di.App,
+		Build: func(ctx di.Context) (interface{}, error) {
+			config := ctx.Get("config").(*Config)
+			switch config.DbConfig.Driver {
+			case DB_DRIVER_MONGO, "":
+				client := ctx.Get("mongo").(*mongodriver.Client)
+				return mongo.NewCertificateRepository(client.Database(config.DbConfig.Name), time.Duration(config.DbConfig.Timeout)*time.Second)
+			case DB_DRIVER_MEMORY:
+				return memory.NewCertificateRepository(), nil
+			case DB_DRIVER_SQLITE, DB_DRIVER_POSTGRES:
+				db, err := sqldb.Open(config.DbConfig.Driver, config.DbConfig.Dsn)
+				if err != nil {
+					return nil, err
+				}
+				return sqldb.NewCertificateRepository(db, config.DbConfig.Driver)
+			case DB_DRIVER_BOLT:
+				db, err := boltdb.Open(config.DbConfig.Dsn)
+				if err != nil {
+					return nil, err
+				}
+				return boltdb.NewCertificateRepository(db)
 			}
-			//defer session.Close()
-			session.SetMode(mgo.Monotonic, true)
-			return session, nil
+			return nil, errors.New(fmt.Sprintf("Unknown db driver %s", config.DbConfig.Driver))
 		},
 	})
 
@@ -143,29 +334,204 @@ func main() {
 			if err != nil {
 				logger.Criticalf("Cant't read root cerificate %s", config.RootCertPath)
 			}
-			key, err := ioutil.ReadFile(config.RootCertKeyPath)
+			key, err := loadSigner(config.RootKey, config.RootCertKeyPath)
 			if err != nil {
-				logger.Criticalf("Cant't read root cerificate private key %s", config.RootCertKeyPath)
+				logger.Fatalf("Cant't load root cerificate private key: %s", err)
+			}
+			if err = g.LoadRootCA(crt, key); err != nil {
+				logger.Fatal(err)
+			}
+			canSign := key != nil
+			for _, root := range config.TrustedRoots {
+				crt, err := ioutil.ReadFile(root.CertPath)
+				if err != nil {
+					logger.Fatalf("Can't read trusted root certificate %s", root.CertPath)
+				}
+				var retireAfter time.Time
+				if root.RetireAfter != "" {
+					if retireAfter, err = time.Parse(time.RFC3339, root.RetireAfter); err != nil {
+						logger.Fatalf("Invalid retire date of trusted root %s: %s", root.CertPath, err)
+					}
+				}
+				if err = g.AddTrustedRoot(crt, retireAfter); err != nil {
+					logger.Fatal(err)
+				}
+			}
+			for _, path := range config.CrossCertPaths {
+				crt, err := ioutil.ReadFile(path)
+				if err != nil {
+					logger.Fatalf("Can't read cross certificate %s", path)
+				}
+				if err = g.AddCrossCertificate(crt); err != nil {
+					logger.Fatal(err)
+				}
+			}
+			for _, ca := range config.IssuingCAs {
+				crt, err := ioutil.ReadFile(ca.CertPath)
+				if err != nil {
+					logger.Fatalf("Can't read intermediate certificate %s", ca.CertPath)
+				}
+				var chain []byte
+				if ca.ChainPath != "" {
+					if chain, err = ioutil.ReadFile(ca.ChainPath); err != nil {
+						logger.Fatalf("Can't read intermediate certificate chain %s", ca.ChainPath)
+					}
+				}
+				key, err := loadSigner(ca.Key, ca.KeyPath)
+				if err != nil {
+					logger.Fatalf("Can't load intermediate certificate private key: %s", err)
+				}
+				if err = g.AddIntermediateCA(crt, chain, key, ca.Active); err != nil {
+					logger.Fatal(err)
+				}
+				canSign = canSign || ca.Active
+			}
+			if !canSign {
+				logger.Fatal("No signing CA is available, configure an active intermediate CA or the root key")
 			}
-			g.LoadRootCA(crt, key)
 			g.DefaultTTL = config.CertTTL
 			g.RsaBits = config.KeyRSABits
+			if !generator.IsSupportedKeyAlgorithm(config.KeyAlgorithm) {
+				logger.Criticalf("Unsupported key algorithm %s", config.KeyAlgorithm)
+			}
+			g.KeyAlgorithm = config.KeyAlgorithm
+			g.SanPolicy = generator.SanPolicy{
+				DNS:   config.SanAllowlist.DNS,
+				IP:    config.SanAllowlist.IP,
+				URI:   config.SanAllowlist.URI,
+				Email: config.SanAllowlist.Email,
+			}
+			g.Profiles, err = buildProfiles(config)
+			if err != nil {
+				logger.Criticalf("Invalid certificate profiles: %s", err)
+			}
+			if p, ok := g.Profiles[config.DefaultProfile]; !ok {
+				logger.Criticalf("Default certificate profile %s is not defined", config.DefaultProfile)
+			} else if p.IsCA {
+				logger.Criticalf("Default certificate profile %s must not issue CA certificates", config.DefaultProfile)
+			}
+			g.DefaultProfile = config.DefaultProfile
+			g.Pkcs12Legacy = config.Pkcs12Legacy
+			if !generator.IsSupportedKDF(config.KeyEncryption.KDF) {
+				logger.Criticalf("Unsupported key derivation function %s", config.KeyEncryption.KDF)
+			}
+			g.KeyEncryption = generator.KeyEncryption{
+				KDF:        config.KeyEncryption.KDF,
+				Iterations: config.KeyEncryption.PBKDF2Iterations,
+				ScryptCost: config.KeyEncryption.ScryptCost,
+				LegacyPEM:  config.KeyEncryption.LegacyPEM,
+			}
+			if config.CRLConfig.URL != "" {
+				g.CRLDistributionPoints = []string{config.CRLConfig.URL}
+			}
+			if config.OCSPConfig.URL != "" {
+				g.OCSPServers = []string{config.OCSPConfig.URL}
+			}
+			g.OCSPResponderTTL = time.Duration(config.OCSPConfig.ResponderTTL) * 24 * time.Hour
+			if config.OCSPConfig.ResponderCertPath != "" {
+				crt, err := ioutil.ReadFile(config.OCSPConfig.ResponderCertPath)
+				if err != nil {
+					logger.Fatalf("Can't read OCSP responder certificate %s", config.OCSPConfig.ResponderCertPath)
+				}
+				key, err := loadSigner(config.OCSPConfig.ResponderKey, config.OCSPConfig.ResponderKeyPath)
+				if err != nil {
+					logger.Fatalf("Can't load OCSP responder private key: %s", err)
+				}
+				if err = g.LoadOCSPResponder(crt, key); err != nil {
+					logger.Fatal(err)
+				}
+			}
+			if config.IdentityOid != "" {
+				g.IdentityOid, err = generator.ParseOID(config.IdentityOid)
+				if err != nil {
+					logger.Critical(err)
+				}
+			} else {
+				logger.Warningf("identity_oid is not configured, using placeholder OID %s which is not registered to you, "+
+					"configure an OID under your private enterprise number", generator.DefaultIdentityOid)
+			}
 			return g, nil
 		},
 	})
+	builder.AddDefinition(di.Definition{
+		Name:  "crl",
+		Scope: di.App,
+		Build: func(ctx di.Context) (interface{}, error) {
+			config := ctx.Get("config").(*Config)
+			gen := ctx.Get("generator").(generator.Generator)
+			repository := ctx.Get("repository").(certificate.Repository)
+
+			return service.NewCRLService(repository, gen, time.Duration(config.CRLConfig.NextUpdate)*time.Hour), nil
+		},
+	})
+	builder.AddDefinition(di.Definition{
+		Name:  "ocsp",
+		Scope: di.App,
+		Build: func(ctx di.Context) (interface{}, error) {
+			config := ctx.Get("config").(*Config)
+			gen := ctx.Get("generator").(generator.Generator)
+			repository := ctx.Get("repository").(certificate.Repository)
+
+			return service.NewOCSPService(repository, gen, time.Duration(config.OCSPConfig.NextUpdate)*time.Minute), nil
+		},
+	})
+	builder.AddDefinition(di.Definition{
+		Name:  "challenges",
+		Scope: di.App,
+		Build: func(ctx di.Context) (interface{}, error) {
+			config := ctx.Get("config").(*Config)
+			return service.NewChallengeService(
+				time.Duration(config.ChallengeConfig.TTL)*time.Second,
+				config.ChallengeConfig.MaxOutstanding,
+				config.ChallengeConfig.MaxPerIdentity,
+				config.ChallengeConfig.MaxPerClient,
+			), nil
+		},
+	})
 	context = builder.Build()
 
 	router := context.Get("router").(*httprouter.Router)
 	config := context.Get("config").(*Config)
+	// load the CA keys before serving, the passphrase may be prompted
+	gen := context.Get("generator").(*generator.CryptoTLS)
+
+	if *cliCrossSign != "" {
+		crt, err := ioutil.ReadFile(*cliCrossSign)
+		if err != nil {
+			logger.Fatalf("Can't read root certificate %s", *cliCrossSign)
+		}
+		cross, err := gen.CrossSign(crt)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		os.Stdout.Write(cross)
+		return
+	}
 
 	cron := crontab.New()
 	cron.AddJob("* * * * *", CleanUp)
+	cron.AddJob("* * * * *", SweepChallenges)
+	cron.AddJob(config.CRLConfig.Schedule, RefreshCRL)
+
+	tlsConfig := &tls.Config{}
+	switch config.HttpConfig.ClientAuth {
+	case CLIENT_AUTH_NONE, "":
+	case CLIENT_AUTH_REQUEST, CLIENT_AUTH_REQUIRE:
+		// the chain is verified by the generator to honour intermediates and retired roots,
+		// require is enforced per route as enrollment, CRL and OCSP are served to clients without a certificate
+		tlsConfig.ClientAuth = tls.RequestClientCert
+		tlsConfig.ClientCAs = gen.ClientCAs()
+		tlsConfig.VerifyPeerCertificate = gen.VerifyPeerCertificate
+	default:
+		logger.Fatalf("Unknown client_auth mode %s", config.HttpConfig.ClientAuth)
+	}
 
-	err := http.ListenAndServeTLS(
-		fmt.Sprintf("%s:%d", config.HttpConfig.Listen, config.HttpConfig.Port),
-		config.HttpConfig.SSLCertPath,
-		config.HttpConfig.SSLCertKeyPath,
-		router)
+	server := &http.Server{
+		Addr:      fmt.Sprintf("%s:%d", config.HttpConfig.Listen, config.HttpConfig.Port),
+		Handler:   router,
+		TLSConfig: tlsConfig,
+	}
+	err := server.ListenAndServeTLS(config.HttpConfig.SSLCertPath, config.HttpConfig.SSLCertKeyPath)
 	if err != nil {
 		logger.Fatal(err)
 	}
@@ -192,21 +558,45 @@ func GenerateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 		response.Did = gr.Did
 		response.Result = true
 
-		config := context.Get("config").(*Config)
-		session := context.Get("mongo").(*mgo.Session)
 		gen := context.Get("generator").(generator.Generator)
 
-		repository, _ := mongo.NewCertificateRepository(config.DbConfig.Name, session)
+		repository := context.Get("repository").(certificate.Repository)
 		certificateService := service.NewCertificateService(repository, gen)
 
+		if !generator.IsSupportedFormat(gr.Format) {
+			response.Result = false
+			response.Reason = fmt.Sprintf("Unsupported output format %s", gr.Format)
+			done <- response
+			close(done)
+			return
+		}
+
 		o := generator.Options{}
 		o.SetValidFrom(gr.ValidFrom)
 		o.SetValidFor(gr.ValidFor)
 		o.SetPassword(gr.Password)
 		o.SetUid(gr.Uid)
 		o.SetDid(gr.Did)
+		o.SetKeyAlgorithm(gr.KeyAlgorithm)
+		o.SetSubjectAltNames(toGeneratorSans(gr.Sans))
+		o.SetProfile(gr.Profile)
+
+		if err := checkProfile(gen, r, gr.Profile); err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
 
-		c, err := certificateService.GenerateCertificate(o)
+		// the bundle is built before the certificate is stored, a failure keeps the active certificate
+		var c *certificate.Certificate
+		var bundle []byte
+		if gr.Format == generator.FORMAT_PKCS12 {
+			c, bundle, err = certificateService.GenerateBundledCertificate(o, gr.Password)
+		} else {
+			c, err = certificateService.GenerateCertificate(o)
+		}
 		if err != nil {
 			response.Result = false
 			response.Reason = err.Error()
@@ -218,6 +608,16 @@ func GenerateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 		response.Certificate = c.GetCertificateBase64()
 		response.PrivateKey = c.GetPrivateKeyBase64()
 		response.ValidTill = c.GetValidTill().Format(time.RFC3339)
+		sans, _ := gen.ParseSubjectAltNames(c.GetCertificate())
+		response.Sans = fromGeneratorSans(sans)
+		chain, _ := gen.Chain(c.GetCertificate())
+		response.Chain = encodeChain(chain)
+
+		if gr.Format == generator.FORMAT_PKCS12 {
+			// the private key is delivered inside the bundle only
+			response.PrivateKey = ""
+			response.Pkcs12 = base64.StdEncoding.EncodeToString(bundle)
+		}
 		done <- response
 		close(done)
 	}()
@@ -233,36 +633,55 @@ func GenerateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 	w.Write(result)
 }
 
-func ValidateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func SignHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	w.Header().Set("Content-Type", "application/json")
 	var result []byte
 	var err error
-	var vr ValidateRequest
+	var sr SignRequest
 
 	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&vr)
+	err = decoder.Decode(&sr)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("400 - Bad request!"))
+		return
 	}
 	defer r.Body.Close()
 
-	done := make(chan ValidateResponse)
+	done := make(chan SignResponse)
 	go func() {
-		var response ValidateResponse
-		response.Uid = vr.Uid
-		response.Did = vr.Did
+		var response SignResponse
+		response.Uid = sr.Uid
+		response.Did = sr.Did
 		response.Result = true
 
-		config := context.Get("config").(*Config)
-		session := context.Get("mongo").(*mgo.Session)
 		gen := context.Get("generator").(generator.Generator)
 
-		repository, _ := mongo.NewCertificateRepository(config.DbConfig.Name, session)
+		repository := context.Get("repository").(certificate.Repository)
 		certificateService := service.NewCertificateService(repository, gen)
 
-		sDec, err := base64.StdEncoding.DecodeString(vr.Certificate)
-		if err != nil {
+		// the csr may be sent as is or in base64 encode
+		csr := []byte(sr.Csr)
+		if !strings.HasPrefix(strings.TrimSpace(sr.Csr), "-----BEGIN") {
+			csr, err = base64.StdEncoding.DecodeString(sr.Csr)
+			if err != nil {
+				response.Result = false
+				response.Reason = err.Error()
+				done <- response
+				close(done)
+				return
+			}
+		}
+
+		o := generator.Options{}
+		o.SetValidFrom(sr.ValidFrom)
+		o.SetValidFor(sr.ValidFor)
+		o.SetUid(sr.Uid)
+		o.SetDid(sr.Did)
+		o.SetSubjectAltNames(toGeneratorSans(sr.Sans))
+		o.SetProfile(sr.Profile)
+
+		if err := checkProfile(gen, r, sr.Profile); err != nil {
 			response.Result = false
 			response.Reason = err.Error()
 			done <- response
@@ -270,11 +689,21 @@ func ValidateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 			return
 		}
 
-		response.Result, err = certificateService.ValidateCertificate(vr.Uid, vr.Did, fmt.Sprintf("%s", sDec))
+		c, err := certificateService.SignCertificate(string(csr), o)
 		if err != nil {
+			response.Result = false
 			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
 		}
 
+		response.Certificate = c.GetCertificateBase64()
+		response.ValidTill = c.GetValidTill().Format(time.RFC3339)
+		sans, _ := gen.ParseSubjectAltNames(c.GetCertificate())
+		response.Sans = fromGeneratorSans(sans)
+		chain, _ := gen.Chain(c.GetCertificate())
+		response.Chain = encodeChain(chain)
 		done <- response
 		close(done)
 	}()
@@ -290,79 +719,50 @@ func ValidateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 	w.Write(result)
 }
 
-func ValidateWithNewCertificateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+// ChallengeHandler issues a nonce which the client signs with the certificate key to prove its possession
+func ChallengeHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	w.Header().Set("Content-Type", "application/json")
 	var result []byte
 	var err error
-	var vr ValidateRequestWithNewCertificate
+	var cr ChallengeRequest
 
 	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&vr)
+	err = decoder.Decode(&cr)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("400 - Bad request!"))
+		return
 	}
 	defer r.Body.Close()
 
-	done := make(chan ValidateResponseWithNewCertificate)
+	done := make(chan ChallengeResponse)
 	go func() {
-		var response ValidateResponseWithNewCertificate
-		response.Uid = vr.Uid
-		response.Did = vr.Did
+		var response ChallengeResponse
+		response.Uid = cr.Uid
+		response.Did = cr.Did
 		response.Result = true
 
-		config := context.Get("config").(*Config)
-		session := context.Get("mongo").(*mgo.Session)
-		gen := context.Get("generator").(generator.Generator)
-
-		repository, _ := mongo.NewCertificateRepository(config.DbConfig.Name, session)
-		certificateService := service.NewCertificateService(repository, gen)
+		challengeService := context.Get("challenges").(*service.ChallengeService)
 
-		//ok if cert have a level 3 or lower
-		isL3 := false
-		itrCrt := certificateService.FetchActiveCertificateByUidAndDid(vr.Uid, vr.Did)
-		if itrCrt != nil && itrCrt.GetCertificateBase64() != vr.Certificate {
-			isL3 = true
+		if cr.Uid == "" || cr.Did == "" {
+			response.Result = false
+			response.Reason = "UID and DID are required"
+			done <- response
+			close(done)
+			return
 		}
-
-		if isL3 {
-			o := generator.Options{}
-			o.SetValidFrom(vr.ValidFrom)
-			o.SetValidFor(vr.ValidFor)
-			o.SetPassword(vr.Password)
-			o.SetUid(vr.Uid)
-			o.SetDid(vr.Did)
-
-			cert, err := certificateService.GenerateCertificate(o)
-			if err != nil {
-				response.Result = false
-				response.Reason = err.Error()
-				done <- response
-				close(done)
-				return
-			}
-
-			response.Certificate = cert.GetCertificateBase64()
-			response.PrivateKey = cert.GetPrivateKeyBase64()
-			response.ValidTill = cert.GetValidTill().Format(time.RFC3339)
+		// the client is the remote address, the port changes between connections
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		challenge, expires, err := challengeService.Issue(cr.Uid, cr.Did, client)
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
 		} else {
-			// Specify why do this ?
-			sDec, err := base64.StdEncoding.DecodeString(vr.Certificate)
-			if err != nil {
-				response.Result = false
-				response.Reason = err.Error()
-				done <- response
-				close(done)
-				return
-			}
-
-			response.Result, err = certificateService.ValidateCertificate(vr.Uid, vr.Did, fmt.Sprintf("%s", sDec))
-			if err != nil {
-				response.Reason = err.Error()
-				done <- response
-				close(done)
-				return
-			}
+			response.Challenge = challenge
+			response.ExpiresAt = expires.Format(time.RFC3339)
 		}
 
 		done <- response
@@ -380,15 +780,15 @@ func ValidateWithNewCertificateHandler(w http.ResponseWriter, r *http.Request, _
 	w.Write(result)
 }
 
-
-func WithdrawalHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+// ValidateProofHandler validates the certificate like ValidateHandler and checks the signature over the challenge
+func ValidateProofHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	w.Header().Set("Content-Type", "application/json")
 	var result []byte
 	var err error
-	var wr WithdrawalRequest
+	var vr ValidateProofRequest
 
 	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&wr)
+	err = decoder.Decode(&vr)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("400 - Bad request!"))
@@ -396,30 +796,29 @@ func WithdrawalHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Para
 	}
 	defer r.Body.Close()
 
-	done := make(chan WithdrawalResponse)
+	done := make(chan ValidateResponse)
 	go func() {
-		var response WithdrawalResponse
-		response.Uid = wr.Uid
-		response.Did = wr.Did
+		var response ValidateResponse
+		response.Uid = vr.Uid
+		response.Did = vr.Did
 		response.Result = true
 
-		config := context.Get("config").(*Config)
-		session := context.Get("mongo").(*mgo.Session)
 		gen := context.Get("generator").(generator.Generator)
+		challengeService := context.Get("challenges").(*service.ChallengeService)
 
-		repository, _ := mongo.NewCertificateRepository(config.DbConfig.Name, session)
+		repository := context.Get("repository").(certificate.Repository)
 		certificateService := service.NewCertificateService(repository, gen)
 
-		sDec, err := base64.StdEncoding.DecodeString(wr.Certificate)
-		if err != nil {
+		if err := challengeService.Consume(vr.Challenge, vr.Uid, vr.Did); err != nil {
 			response.Result = false
 			response.Reason = err.Error()
+			response.Code = validationCode(err)
 			done <- response
 			close(done)
 			return
 		}
 
-		_, err = certificateService.ValidateCertificate(wr.Uid, wr.Did, fmt.Sprintf("%s", sDec))
+		sDec, err := base64.StdEncoding.DecodeString(vr.Certificate)
 		if err != nil {
 			response.Result = false
 			response.Reason = err.Error()
@@ -427,30 +826,22 @@ func WithdrawalHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Para
 			close(done)
 			return
 		}
-
-		cert, err := certificateService.FetchCertificateObjectByItContent(fmt.Sprintf("%s", sDec))
+		signature, err := base64.StdEncoding.DecodeString(vr.Signature)
 		if err != nil {
 			response.Result = false
-			response.Reason = err.Error()
-			done <- response
-			close(done)
-			return
-		}
-		if cert == nil {
-			response.Result = false
-			response.Reason = "Certificate not found"
+			response.Reason = fmt.Sprintf("Failed to decode signature: %s", err)
 			done <- response
 			close(done)
 			return
 		}
 
-		err = certificateService.Withdraw(cert)
+		report := certificateService.PossessionReport(vr.Uid, vr.Did, fmt.Sprintf("%s", sDec), vr.Challenge, signature)
+		response.Report = toValidationReport(report)
+		err = report.Err()
+		response.Result = err == nil
 		if err != nil {
-			response.Result = false
 			response.Reason = err.Error()
-			done <- response
-			close(done)
-			return
+			response.Code = validationCode(err)
 		}
 
 		done <- response
@@ -468,14 +859,915 @@ func WithdrawalHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Para
 	w.Write(result)
 }
 
-func CleanUp() {
+// ValidateClientHandler validates the client certificate presented in the TLS handshake,
+// the UID and DID are taken from the certificate
+func ValidateClientHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	var result []byte
+	var err error
+
+	done := make(chan ValidateResponse)
 	go func() {
-		config := context.Get("config").(*Config)
-		session := context.Get("mongo").(*mgo.Session)
+		var response ValidateResponse
+		response.Result = true
+
 		gen := context.Get("generator").(generator.Generator)
 
-		repository, _ := mongo.NewCertificateRepository(config.DbConfig.Name, session)
+		repository := context.Get("repository").(certificate.Repository)
 		certificateService := service.NewCertificateService(repository, gen)
-		certificateService.RemoveExpired()
+
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			response.Result = false
+			response.Reason = "No client certificate presented"
+			done <- response
+			close(done)
+			return
+		}
+		candidate := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: r.TLS.PeerCertificates[0].Raw}))
+
+		uid, did, err := gen.ParseUidDid(candidate)
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
+		response.Uid = uid
+		response.Did = did
+
+		report := certificateService.ValidationReport(uid, did, candidate)
+		response.Report = toValidationReport(report)
+		err = report.Err()
+		response.Result = err == nil
+		if err != nil {
+			response.Reason = err.Error()
+			response.Code = validationCode(err)
+		}
+
+		done <- response
+		close(done)
+	}()
+
+	result, err = json.Marshal(<-done)
+	if err != nil {
+		msg := fmt.Sprintf("Internal Server Error: %s", err)
+		logger.Error(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(result)
+}
+
+func ValidateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	var result []byte
+	var err error
+	var vr ValidateRequest
+
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&vr)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request!"))
+	}
+	defer r.Body.Close()
+
+	done := make(chan ValidateResponse)
+	go func() {
+		var response ValidateResponse
+		response.Uid = vr.Uid
+		response.Did = vr.Did
+		response.Result = true
+
+		gen := context.Get("generator").(generator.Generator)
+
+		repository := context.Get("repository").(certificate.Repository)
+		certificateService := service.NewCertificateService(repository, gen)
+
+		sDec, err := base64.StdEncoding.DecodeString(vr.Certificate)
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
+
+		at := time.Now()
+		if vr.At != "" {
+			at, err = time.Parse(time.RFC3339, vr.At)
+			if err != nil {
+				response.Result = false
+				response.Reason = fmt.Sprintf("Failed to parse validation time: %s", err)
+				done <- response
+				close(done)
+				return
+			}
+		}
+
+		report := certificateService.ValidationReportAt(vr.Uid, vr.Did, fmt.Sprintf("%s", sDec), at)
+		response.Report = toValidationReport(report)
+		err = report.Err()
+		response.Result = err == nil
+		if err != nil {
+			response.Reason = err.Error()
+			response.Code = validationCode(err)
+		}
+
+		done <- response
+		close(done)
+	}()
+
+	result, err = json.Marshal(<-done)
+	if err != nil {
+		msg := fmt.Sprintf("Internal Server Error: %s", err)
+		logger.Error(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(result)
+}
+
+func ValidateWithNewCertificateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	var result []byte
+	var err error
+	var vr ValidateRequestWithNewCertificate
+
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&vr)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request!"))
+	}
+	defer r.Body.Close()
+
+	done := make(chan ValidateResponseWithNewCertificate)
+	go func() {
+		var response ValidateResponseWithNewCertificate
+		response.Uid = vr.Uid
+		response.Did = vr.Did
+		response.Result = true
+
+		gen := context.Get("generator").(generator.Generator)
+
+		repository := context.Get("repository").(certificate.Repository)
+		certificateService := service.NewCertificateService(repository, gen)
+
+		//ok if cert have a level 3 or lower
+		isL3 := false
+		itrCrt := certificateService.FetchActiveCertificateByUidAndDid(vr.Uid, vr.Did)
+		if itrCrt != nil && itrCrt.GetCertificateBase64() != vr.Certificate {
+			isL3 = true
+		}
+
+		if isL3 {
+			// the presented certificate must be valid to get a new one
+			sDec, err := base64.StdEncoding.DecodeString(vr.Certificate)
+			if err != nil {
+				response.Result = false
+				response.Reason = err.Error()
+				done <- response
+				close(done)
+				return
+			}
+			response.Result, err = certificateService.ValidateCertificate(vr.Uid, vr.Did, fmt.Sprintf("%s", sDec))
+			if err != nil {
+				response.Reason = err.Error()
+				response.Code = validationCode(err)
+				done <- response
+				close(done)
+				return
+			}
+
+			if !generator.IsSupportedFormat(vr.Format) {
+				response.Result = false
+				response.Reason = fmt.Sprintf("Unsupported output format %s", vr.Format)
+				done <- response
+				close(done)
+				return
+			}
+
+			o := generator.Options{}
+			o.SetValidFrom(vr.ValidFrom)
+			o.SetValidFor(vr.ValidFor)
+			o.SetPassword(vr.Password)
+			o.SetUid(vr.Uid)
+			o.SetDid(vr.Did)
+			o.SetKeyAlgorithm(vr.KeyAlgorithm)
+			o.SetSubjectAltNames(toGeneratorSans(vr.Sans))
+			o.SetProfile(vr.Profile)
+
+			if err := checkProfile(gen, r, vr.Profile); err != nil {
+				response.Result = false
+				response.Reason = err.Error()
+				done <- response
+				close(done)
+				return
+			}
+
+			var cert *certificate.Certificate
+			var bundle []byte
+			if vr.Format == generator.FORMAT_PKCS12 {
+				cert, bundle, err = certificateService.GenerateBundledCertificate(o, vr.Password)
+			} else {
+				cert, err = certificateService.GenerateCertificate(o)
+			}
+			if err != nil {
+				response.Result = false
+				response.Reason = err.Error()
+				done <- response
+				close(done)
+				return
+			}
+
+			response.Certificate = cert.GetCertificateBase64()
+			response.PrivateKey = cert.GetPrivateKeyBase64()
+			response.ValidTill = cert.GetValidTill().Format(time.RFC3339)
+			sans, _ := gen.ParseSubjectAltNames(cert.GetCertificate())
+			response.Sans = fromGeneratorSans(sans)
+			chain, _ := gen.Chain(cert.GetCertificate())
+			response.Chain = encodeChain(chain)
+
+			if vr.Format == generator.FORMAT_PKCS12 {
+				response.PrivateKey = ""
+				response.Pkcs12 = base64.StdEncoding.EncodeToString(bundle)
+			}
+		} else {
+			// Specify why do this ?
+			sDec, err := base64.StdEncoding.DecodeString(vr.Certificate)
+			if err != nil {
+				response.Result = false
+				response.Reason = err.Error()
+				done <- response
+				close(done)
+				return
+			}
+
+			response.Result, err = certificateService.ValidateCertificate(vr.Uid, vr.Did, fmt.Sprintf("%s", sDec))
+			if err != nil {
+				response.Reason = err.Error()
+				response.Code = validationCode(err)
+				done <- response
+				close(done)
+				return
+			}
+		}
+
+		done <- response
+		close(done)
+	}()
+
+	result, err = json.Marshal(<-done)
+	if err != nil {
+		msg := fmt.Sprintf("Internal Server Error: %s", err)
+		logger.Error(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(result)
+}
+
+
+func WithdrawalHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	var result []byte
+	var err error
+	var wr WithdrawalRequest
+
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&wr)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request!"))
+		return
+	}
+	defer r.Body.Close()
+
+	done := make(chan WithdrawalResponse)
+	go func() {
+		var response WithdrawalResponse
+		response.Uid = wr.Uid
+		response.Did = wr.Did
+		response.Result = true
+
+		gen := context.Get("generator").(generator.Generator)
+
+		repository := context.Get("repository").(certificate.Repository)
+		certificateService := service.NewCertificateService(repository, gen)
+
+		reason, err := certificate.ParseWithdrawalReason(wr.Reason)
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
+		var invalidityDate time.Time
+		if wr.InvalidityDate != "" {
+			invalidityDate, err = time.Parse(time.RFC3339, wr.InvalidityDate)
+			if err != nil {
+				response.Result = false
+				response.Reason = fmt.Sprintf("Failed to parse invalidity date: %s", err)
+				done <- response
+				close(done)
+				return
+			}
+		}
+
+		sDec, err := base64.StdEncoding.DecodeString(wr.Certificate)
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
+
+		// certificates on hold may be withdrawn permanently
+		cert := certificateService.FetchSuspendedCertificate(wr.Uid, wr.Did, fmt.Sprintf("%s", sDec))
+		if cert == nil {
+			_, err = certificateService.ValidateCertificate(wr.Uid, wr.Did, fmt.Sprintf("%s", sDec))
+			if err != nil {
+				response.Result = false
+				response.Reason = err.Error()
+				done <- response
+				close(done)
+				return
+			}
+
+			cert, err = certificateService.FetchCertificateObjectByItContent(fmt.Sprintf("%s", sDec))
+			if err != nil {
+				response.Result = false
+				response.Reason = err.Error()
+				done <- response
+				close(done)
+				return
+			}
+			if cert == nil {
+				response.Result = false
+				response.Reason = "Certificate not found"
+				done <- response
+				close(done)
+				return
+			}
+		}
+
+		err = certificateService.Withdraw(cert, reason, wr.Comment, invalidityDate, wr.Actor)
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
+		RefreshCRL()
+
+		done <- response
+		close(done)
+	}()
+
+	result, err = json.Marshal(<-done)
+	if err != nil {
+		msg := fmt.Sprintf("Internal Server Error: %s", err)
+		logger.Error(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(result)
+}
+
+// AdminRevokeHandler withdraws certificates by serial, uid or did without presenting them,
+// the caller is authorized with the admin_token bearer token
+func AdminRevokeHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	var result []byte
+	var err error
+	var ar AdminRevokeRequest
+
+	config := context.Get("config").(*Config)
+	if !isAdmin(config, r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized!"))
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&ar)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request!"))
+		return
+	}
+	defer r.Body.Close()
+
+	done := make(chan AdminRevokeResponse)
+	go func() {
+		var response AdminRevokeResponse
+		response.Serials = []string{}
+		response.Result = true
+
+		gen := context.Get("generator").(generator.Generator)
+
+		repository := context.Get("repository").(certificate.Repository)
+		certificateService := service.NewCertificateService(repository, gen)
+
+		reason, err := certificate.ParseWithdrawalReason(ar.Reason)
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
+		var invalidityDate time.Time
+		if ar.InvalidityDate != "" {
+			invalidityDate, err = time.Parse(time.RFC3339, ar.InvalidityDate)
+			if err != nil {
+				response.Result = false
+				response.Reason = fmt.Sprintf("Failed to parse invalidity date: %s", err)
+				done <- response
+				close(done)
+				return
+			}
+		}
+
+		var serials []string
+		switch {
+		case ar.Serial != "" && ar.Uid == "" && ar.Did == "":
+			serials, err = certificateService.RevokeBySerial(ar.Serial, reason, ar.Comment, invalidityDate, ar.Actor)
+		case ar.Uid != "" && ar.Serial == "" && ar.Did == "":
+			serials, err = certificateService.RevokeByUid(ar.Uid, reason, ar.Comment, invalidityDate, ar.Actor)
+		case ar.Did != "" && ar.Serial == "" && ar.Uid == "":
+			serials, err = certificateService.RevokeByDid(ar.Did, reason, ar.Comment, invalidityDate, ar.Actor)
+		default:
+			err = errors.New("Exactly one of serial, uid or did must be given")
+		}
+		if serials != nil {
+			response.Serials = serials
+		}
+		if len(response.Serials) > 0 {
+			RefreshCRL()
+		}
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+		}
+
+		done <- response
+		close(done)
+	}()
+
+	result, err = json.Marshal(<-done)
+	if err != nil {
+		msg := fmt.Sprintf("Internal Server Error: %s", err)
+		logger.Error(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(result)
+}
+
+// checkProfile allows profiles issuing CA certificates to admins only
+func checkProfile(gen generator.Generator, r *http.Request, profile string) error {
+	isCA, err := gen.IsCAProfile(profile)
+	if err != nil {
+		return err
+	}
+	if isCA && !isAdmin(context.Get("config").(*Config), r) {
+		return errors.New(fmt.Sprintf("Certificate profile %s issues CA certificates and requires the admin token", profile))
+	}
+	return nil
+}
+
+// isAdmin checks the bearer token of the request, admin endpoints are disabled without admin_token
+func isAdmin(config *Config, r *http.Request) bool {
+	if config.AdminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+}
+
+// SuspendHandler puts the active certificate on hold, the caller is authorized with the admin_token bearer token
+func SuspendHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	var result []byte
+	var err error
+	var sr SuspendRequest
+
+	config := context.Get("config").(*Config)
+	if !isAdmin(config, r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized!"))
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&sr)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request!"))
+		return
+	}
+	defer r.Body.Close()
+
+	done := make(chan SuspendResponse)
+	go func() {
+		var response SuspendResponse
+		response.Uid = sr.Uid
+		response.Did = sr.Did
+		response.Result = true
+
+		gen := context.Get("generator").(generator.Generator)
+
+		repository := context.Get("repository").(certificate.Repository)
+		certificateService := service.NewCertificateService(repository, gen)
+
+		sDec, err := base64.StdEncoding.DecodeString(sr.Certificate)
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
+
+		_, err = certificateService.ValidateCertificate(sr.Uid, sr.Did, fmt.Sprintf("%s", sDec))
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
+
+		cert, err := certificateService.FetchCertificateObjectByItContent(fmt.Sprintf("%s", sDec))
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
+		if cert == nil {
+			response.Result = false
+			response.Reason = "Certificate not found"
+			done <- response
+			close(done)
+			return
+		}
+
+		err = certificateService.Suspend(cert, sr.Comment, sr.Actor)
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
+		RefreshCRL()
+
+		done <- response
+		close(done)
+	}()
+
+	result, err = json.Marshal(<-done)
+	if err != nil {
+		msg := fmt.Sprintf("Internal Server Error: %s", err)
+		logger.Error(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(result)
+}
+
+// ReinstateHandler releases the hold of the suspended certificate,
+// the caller is authorized with the admin_token bearer token
+func ReinstateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	var result []byte
+	var err error
+	var sr SuspendRequest
+
+	config := context.Get("config").(*Config)
+	if !isAdmin(config, r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 - Unauthorized!"))
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&sr)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - Bad request!"))
+		return
+	}
+	defer r.Body.Close()
+
+	done := make(chan SuspendResponse)
+	go func() {
+		var response SuspendResponse
+		response.Uid = sr.Uid
+		response.Did = sr.Did
+		response.Result = true
+
+		gen := context.Get("generator").(generator.Generator)
+
+		repository := context.Get("repository").(certificate.Repository)
+		certificateService := service.NewCertificateService(repository, gen)
+
+		sDec, err := base64.StdEncoding.DecodeString(sr.Certificate)
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
+
+		cert := certificateService.FetchSuspendedCertificate(sr.Uid, sr.Did, fmt.Sprintf("%s", sDec))
+		if cert == nil {
+			response.Result = false
+			response.Reason = "Suspended certificate with given UID and DID not found"
+			done <- response
+			close(done)
+			return
+		}
+
+		err = certificateService.Reinstate(cert)
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
+		RefreshCRL()
+
+		done <- response
+		close(done)
+	}()
+
+	result, err = json.Marshal(<-done)
+	if err != nil {
+		msg := fmt.Sprintf("Internal Server Error: %s", err)
+		logger.Error(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(result)
+}
+
+// CertificateHandler looks up a certificate by its decimal serial number
+func CertificateHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	var result []byte
+	var err error
+
+	done := make(chan CertificateResponse)
+	go func() {
+		var response CertificateResponse
+		response.Serial = ps.ByName("serial")
+		response.Result = true
+
+		gen := context.Get("generator").(generator.Generator)
+
+		repository := context.Get("repository").(certificate.Repository)
+		certificateService := service.NewCertificateService(repository, gen)
+
+		cert, err := certificateService.FetchCertificateBySerial(response.Serial)
+		if err != nil {
+			response.Result = false
+			response.Reason = err.Error()
+			done <- response
+			close(done)
+			return
+		}
+
+		response.Uid = cert.GetUid()
+		response.Did = cert.GetDid()
+		response.Certificate = cert.GetCertificateBase64()
+		response.Status = certificate.StatusName(cert.GetStatus())
+		response.ValidTill = cert.GetValidTill().Format(time.RFC3339)
+		if cert.GetStatus() == certificate.STATUS_WITHDRAWN || cert.GetStatus() == certificate.STATUS_SUSPENDED {
+			response.Revocation = &RevocationInfo{
+				Reason:    certificate.ReasonName(cert.GetRevocationReason()),
+				Comment:   cert.GetRevocationComment(),
+				RevokedBy: cert.GetRevokedBy(),
+				RevokedAt: cert.GetWithdrawalDateTime().Format(time.RFC3339),
+			}
+			if !cert.GetInvalidityDate().IsZero() {
+				response.Revocation.InvalidityDate = cert.GetInvalidityDate().Format(time.RFC3339)
+			}
+		}
+		done <- response
+		close(done)
+	}()
+
+	result, err = json.Marshal(<-done)
+	if err != nil {
+		msg := fmt.Sprintf("Internal Server Error: %s", err)
+		logger.Error(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(result)
+}
+
+// CRLHandler serves the current CRL of the issuer in DER, or in PEM when format=pem is given.
+// The CRL of the signing CA is served when no issuer is given.
+func CRLHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	crlService := context.Get("crl").(*service.CRLService)
+	issuer := strings.ToLower(ps.ByName("issuer"))
+
+	var crl []byte
+	var thisUpdate time.Time
+	var err error
+	if r.URL.Query().Get("format") == generator.FORMAT_PEM {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		crl, thisUpdate, err = crlService.CRLPem(issuer)
+	} else {
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		crl, thisUpdate, err = crlService.CRL(issuer)
+	}
+	if err == generator.ErrUnknownIssuer {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Internal Server Error: %s", err)
+		logger.Error(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Last-Modified", thisUpdate.UTC().Format(http.TimeFormat))
+	w.Write(crl)
+}
+
+// OCSPHandler answers OCSP requests sent with POST or base64 encoded in the GET path (RFC 6960 appendix A)
+func OCSPHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	ocspService := context.Get("ocsp").(*service.OCSPService)
+
+	var request []byte
+	var err error
+	if r.Method == http.MethodGet {
+		encoded, _ := url.PathUnescape(strings.TrimPrefix(ps.ByName("request"), "/"))
+		request, err = base64.StdEncoding.DecodeString(encoded)
+	} else {
+		defer r.Body.Close()
+		request, err = ioutil.ReadAll(io.LimitReader(r.Body, 10000))
+	}
+	if err != nil {
+		w.Write(ocsp.MalformedRequestErrorResponse)
+		return
+	}
+
+	response, nextUpdate, err := ocspService.Respond(request)
+	if err == generator.ErrOCSPUnauthorized {
+		w.Write(ocsp.UnauthorizedErrorResponse)
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to answer OCSP request: %s", err)
+		w.Write(ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	now := time.Now()
+	w.Header().Set("Last-Modified", now.UTC().Format(http.TimeFormat))
+	w.Header().Set("Expires", nextUpdate.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", int(nextUpdate.Sub(now).Seconds())))
+	w.Write(response)
+}
+
+// buildProfiles merges the profiles defined in config over the built in ones
+func buildProfiles(config *Config) (map[string]generator.Profile, error) {
+	profiles := generator.DefaultProfiles()
+	for name, p := range config.Profiles {
+		keyUsage, err := generator.ParseKeyUsage(p.KeyUsage)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("profile %s: %s", name, err))
+		}
+		extKeyUsage, err := generator.ParseExtKeyUsage(p.ExtKeyUsage)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("profile %s: %s", name, err))
+		}
+		profiles[name] = generator.Profile{
+			KeyUsage:        keyUsage,
+			ExtKeyUsage:     extKeyUsage,
+			IsCA:            p.IsCA,
+			MaxPathLen:      p.MaxPathLen,
+			MaxTTL:          time.Duration(p.MaxTTL) * 24 * time.Hour,
+			AllowedSanTypes: p.AllowedSanTypes,
+		}
+	}
+	return profiles, nil
+}
+
+func toGeneratorSans(sans []SubjectAltName) []generator.SubjectAltName {
+	var result []generator.SubjectAltName
+	for _, san := range sans {
+		result = append(result, generator.SubjectAltName{Type: strings.ToLower(san.Type), Value: san.Value})
+	}
+	return result
+}
+
+func fromGeneratorSans(sans []generator.SubjectAltName) []SubjectAltName {
+	result := []SubjectAltName{}
+	for _, san := range sans {
+		result = append(result, SubjectAltName{Type: san.Type, Value: san.Value})
+	}
+	return result
+}
+
+func toValidationReport(report *service.ValidationReport) *ValidationReport {
+	result := &ValidationReport{
+		Sans:   []SubjectAltName{},
+		Chain:  []string{},
+		Status: report.Status,
+		At:     report.At.Format(time.RFC3339),
+		Checks: []ValidationCheck{},
+	}
+	if info := report.Certificate; info != nil {
+		result.Subject = info.Subject
+		result.Issuer = info.Issuer
+		result.Serial = info.Serial.String()
+		result.FingerprintSHA256 = info.FingerprintSHA256
+		result.FingerprintSHA1 = info.FingerprintSHA1
+		result.NotBefore = info.NotBefore.Format(time.RFC3339)
+		result.NotAfter = info.NotAfter.Format(time.RFC3339)
+		result.Uid = info.Uid
+		result.Did = info.Did
+		result.Sans = fromGeneratorSans(info.Sans)
+	}
+	if report.Chain != nil {
+		result.Chain = report.Chain
+	}
+	for _, check := range report.Checks {
+		result.Checks = append(result.Checks, ValidationCheck{
+			Name:    check.Name,
+			Passed:  check.Passed,
+			Code:    check.Code,
+			Message: check.Message,
+		})
+	}
+	return result
+}
+
+// validationCode returns the machine readable code of the validation failure
+func validationCode(err error) string {
+	if verr, ok := err.(*service.ValidationError); ok {
+		return verr.Code
+	}
+	return ""
+}
+
+// encodeChain encodes the PEM certificates of the chain in base64 like the issued certificate
+func encodeChain(chain []string) []string {
+	result := []string{}
+	for _, crt := range chain {
+		result = append(result, base64.StdEncoding.EncodeToString([]byte(crt)))
+	}
+	return result
+}
+
+// RefreshCRL rebuilds the CRLs after the withdrawn certificates changed
+func RefreshCRL() {
+	go func() {
+		crlService := context.Get("crl").(*service.CRLService)
+		if err := crlService.Refresh(); err != nil {
+			logger.Errorf("Failed to refresh CRL: %s", err)
+		}
+	}()
+}
+
+func CleanUp() {
+	go func() {
+		gen := context.Get("generator").(generator.Generator)
+
+		repository := context.Get("repository").(certificate.Repository)
+		certificateService := service.NewCertificateService(repository, gen)
+		certificateService.RemoveExpired()
+	}()
+}
+
+// SweepChallenges drops the expired proof of possession challenges
+func SweepChallenges() {
+	go func() {
+		challengeService := context.Get("challenges").(*service.ChallengeService)
+		challengeService.Sweep()
 	}()
 }
@@ -1,13 +1,44 @@
 package main
 
-import "github.com/julienschmidt/httprouter"
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
 
 func InitRouter() *httprouter.Router {
 	router := httprouter.New()
+	// enrollment, CRL and OCSP stay open to clients without a certificate in client_auth require mode
 	router.POST("/api/v1/generate", GenerateHandler)
-	router.POST("/api/v1/validate", ValidateHandler)
-	router.POST("/api/v1/validateWithGenerate", ValidateWithNewCertificateHandler)
-	router.POST("/api/v1/withdrawal", WithdrawalHandler)
+	router.POST("/api/v1/sign", SignHandler)
+	router.POST("/api/v1/validate", requireClientCert(ValidateHandler))
+	router.GET("/api/v1/validateClient", requireClientCert(ValidateClientHandler))
+	router.POST("/api/v1/challenge", requireClientCert(ChallengeHandler))
+	router.POST("/api/v1/validateWithProof", requireClientCert(ValidateProofHandler))
+	router.POST("/api/v1/validateWithGenerate", requireClientCert(ValidateWithNewCertificateHandler))
+	router.POST("/api/v1/withdrawal", requireClientCert(WithdrawalHandler))
+	router.POST("/api/v1/suspend", requireClientCert(SuspendHandler))
+	router.POST("/api/v1/reinstate", requireClientCert(ReinstateHandler))
+	router.GET("/api/v1/certificates/:serial", requireClientCert(CertificateHandler))
+	router.POST("/api/v1/admin/revoke", requireClientCert(AdminRevokeHandler))
+	router.GET("/crl", CRLHandler)
+	router.GET("/crl/:issuer", CRLHandler)
+	router.GET("/ocsp/*request", OCSPHandler)
+	router.POST("/ocsp", OCSPHandler)
 
 	return router
 }
+
+// requireClientCert rejects requests without a verified client certificate when client_auth is require,
+// the handshake itself only requests the certificate so that the open routes stay reachable
+func requireClientCert(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		config := context.Get("config").(*Config)
+		if config.HttpConfig.ClientAuth == CLIENT_AUTH_REQUIRE && (r.TLS == nil || len(r.TLS.PeerCertificates) == 0) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("401 - Client certificate required!"))
+			return
+		}
+		handle(w, r, ps)
+	}
+}
@@ -0,0 +1,236 @@
+// Package boltdb provides a certificate.Repository in a single bbolt file, no database server is needed.
+//
+// Certificates are kept as JSON by serial in the certificate bucket. Secondary index buckets map
+// the indexed values followed by the serial to nothing, each value prefixed with its length so that
+// no value runs into the next one, lookups scan the prefix of the values:
+//   - uid: uid
+//   - did: did
+//   - status: status
+//   - uid_did_status: uid, did and status
+//   - validtill: expiration time, ordered
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/kuai6/nc-crtmgr/src/certificate"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	certificateBucket  = []byte("certificate")
+	uidBucket          = []byte("uid")
+	didBucket          = []byte("did")
+	statusBucket       = []byte("status")
+	uidDidStatusBucket = []byte("uid_did_status")
+	validTillBucket    = []byte("validtill")
+)
+
+type CertificateRepository struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the database file, it is locked against other processes while open
+func Open(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+}
+
+// NewCertificateRepository creates the missing buckets of the database opened with Open
+func NewCertificateRepository(db *bolt.DB) (certificate.Repository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{certificateBucket, uidBucket, didBucket, statusBucket, uidDidStatusBucket, validTillBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateRepository{db: db}, nil
+}
+
+// Store replaces the certificate with the same serial and its index entries in one transaction
+func (r *CertificateRepository) Store(certificate *certificate.Certificate) error {
+	value, err := json.Marshal(certificate)
+	if err != nil {
+		return err
+	}
+	serial := []byte(certificate.GetSerial())
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(certificateBucket)
+		if previous := bucket.Get(serial); previous != nil {
+			old, err := decode(previous)
+			if err != nil {
+				return err
+			}
+			for name, key := range indexKeys(old) {
+				if err := tx.Bucket([]byte(name)).Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		for name, key := range indexKeys(certificate) {
+			if err := tx.Bucket([]byte(name)).Put(key, nil); err != nil {
+				return err
+			}
+		}
+		return bucket.Put(serial, value)
+	})
+}
+
+func (r *CertificateRepository) Find(serial big.Int) (*certificate.Certificate, error) {
+	var result *certificate.Certificate
+	err := r.db.View(func(tx *bolt.Tx) error {
+		// serials are stored as decimal strings
+		value := tx.Bucket(certificateBucket).Get([]byte(serial.String()))
+		if value == nil {
+			return errors.New(fmt.Sprintf("certificate with serial %s not found", serial.String()))
+		}
+		crt, err := decode(value)
+		result = crt
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *CertificateRepository) FindAll() []*certificate.Certificate {
+	result := []*certificate.Certificate{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(certificateBucket).ForEach(func(_, value []byte) error {
+			crt, err := decode(value)
+			if err != nil {
+				return err
+			}
+			result = append(result, crt)
+			return nil
+		})
+	})
+	if err != nil {
+		return []*certificate.Certificate{}
+	}
+	return result
+}
+
+func (r *CertificateRepository) FindExpired() []*certificate.Certificate {
+	now := indexKey(timeKey(time.Now()))
+	return r.scan(validTillBucket, nil, func(key []byte) bool {
+		return bytes.Compare(key[:len(now)], now) <= 0
+	})
+}
+
+func (r *CertificateRepository) FindByStatus(status int) []*certificate.Certificate {
+	return r.scan(statusBucket, indexKey(statusKey(status)), nil)
+}
+
+func (r *CertificateRepository) FindByUid(uid string) []*certificate.Certificate {
+	return r.scan(uidBucket, indexKey([]byte(uid)), nil)
+}
+
+func (r *CertificateRepository) FindByDid(did string) []*certificate.Certificate {
+	return r.scan(didBucket, indexKey([]byte(did)), nil)
+}
+
+func (r *CertificateRepository) FindByGidAndDidAndStatus(uid string, did string, status int) []*certificate.Certificate {
+	return r.scan(uidDidStatusBucket, indexKey([]byte(uid), []byte(did), statusKey(status)), nil)
+}
+
+// scan returns the certificates of the index entries starting with the prefix, ordered by the key.
+// Without a prefix the scan runs from the start while the condition holds.
+func (r *CertificateRepository) scan(index []byte, prefix []byte, while func(key []byte) bool) []*certificate.Certificate {
+	result := []*certificate.Certificate{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		certificates := tx.Bucket(certificateBucket)
+		c := tx.Bucket(index).Cursor()
+		for key, _ := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = c.Next() {
+			if while != nil && !while(key) {
+				break
+			}
+			value := certificates.Get(serialOf(key))
+			if value == nil {
+				continue
+			}
+			crt, err := decode(value)
+			if err != nil {
+				return err
+			}
+			result = append(result, crt)
+		}
+		return nil
+	})
+	if err != nil {
+		return []*certificate.Certificate{}
+	}
+	return result
+}
+
+func decode(value []byte) (*certificate.Certificate, error) {
+	crt := new(certificate.Certificate)
+	if err := json.Unmarshal(value, crt); err != nil {
+		return nil, err
+	}
+	return crt, nil
+}
+
+// indexKeys returns the index entries of the certificate by bucket name
+func indexKeys(crt *certificate.Certificate) map[string][]byte {
+	serial := []byte(crt.GetSerial())
+	status := statusKey(crt.GetStatus())
+	return map[string][]byte{
+		string(uidBucket):          indexKey([]byte(crt.GetUid()), serial),
+		string(didBucket):          indexKey([]byte(crt.GetDid()), serial),
+		string(statusBucket):       indexKey(status, serial),
+		string(uidDidStatusBucket): indexKey([]byte(crt.GetUid()), []byte(crt.GetDid()), status, serial),
+		string(validTillBucket):    indexKey(timeKey(crt.GetValidTill()), serial),
+	}
+}
+
+// indexKey joins the values each prefixed with its length, an entry ends with the serial as last value
+func indexKey(values ...[]byte) []byte {
+	var key []byte
+	for _, value := range values {
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(value)))
+		key = append(key, length...)
+		key = append(key, value...)
+	}
+	return key
+}
+
+// serialOf returns the serial, the last value of the index entry
+func serialOf(key []byte) []byte {
+	var value []byte
+	for len(key) >= 4 {
+		length := binary.BigEndian.Uint32(key)
+		if uint32(len(key)-4) < length {
+			return nil
+		}
+		value, key = key[4:4+length], key[4+length:]
+	}
+	return value
+}
+
+func statusKey(status int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(status))
+	return key
+}
+
+// timeKey encodes the time so that byte order matches time order, before 1970 as well
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 12)
+	binary.BigEndian.PutUint64(key, uint64(t.Unix())^(1<<63))
+	binary.BigEndian.PutUint32(key[8:], uint32(t.Nanosecond()))
+	return key
+}
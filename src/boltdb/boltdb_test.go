@@ -0,0 +1,35 @@
+package boltdb
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kuai6/nc-crtmgr/src/certificate"
+	"github.com/kuai6/nc-crtmgr/src/certificate/repositorytest"
+)
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) certificate.Repository {
+		db, err := Open(filepath.Join(t.TempDir(), "certificates.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		repository, err := NewCertificateRepository(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repository
+	})
+}
+
+func TestTimeKeyOrder(t *testing.T) {
+	times := []time.Time{{}, time.Unix(-5, 0), time.Unix(0, 0), time.Now()}
+	for i := 1; i < len(times); i++ {
+		if bytes.Compare(timeKey(times[i-1]), timeKey(times[i])) >= 0 {
+			t.Fatalf("key of %s does not sort before key of %s", times[i-1], times[i])
+		}
+	}
+}
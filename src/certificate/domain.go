@@ -9,8 +9,27 @@ const (
 	STATUS_ACTIVE     = 1
 	STATUS_WITHDRAWN  = 2
 	STATUS_NOT_ACTIVE = 0
+	STATUS_SUSPENDED  = 3
 )
 
+var statusNames = map[int]string{
+	STATUS_ACTIVE:     "active",
+	STATUS_WITHDRAWN:  "withdrawn",
+	STATUS_NOT_ACTIVE: "not_active",
+	STATUS_SUSPENDED:  "suspended",
+}
+
+// StatusName returns the name of the status used in API responses
+func StatusName(status int) string {
+	return statusNames[status]
+}
+
+// StatusChange is an entry of the certificate status history
+type StatusChange struct {
+	Status   int
+	DateTime time.Time
+}
+
 type Certificate struct {
 	Uid         string
 	Did         string
@@ -23,6 +42,13 @@ type Certificate struct {
 	WithdrawalDateTime time.Time
 
 	Status int
+
+	RevocationReason  int
+	RevocationComment string
+	InvalidityDate    time.Time
+	RevokedBy         string
+
+	History []StatusChange
 }
 
 func (c *Certificate) SetDid(value string) {
@@ -99,18 +125,94 @@ func (c Certificate) GetWithdrawalDateTime() time.Time {
 	return c.WithdrawalDateTime
 }
 
+func (c *Certificate) SetRevocationReason(value int) {
+	c.RevocationReason = value
+}
+
+func (c Certificate) GetRevocationReason() int {
+	return c.RevocationReason
+}
+
+func (c *Certificate) SetRevocationComment(value string) {
+	c.RevocationComment = value
+}
+
+func (c Certificate) GetRevocationComment() string {
+	return c.RevocationComment
+}
+
+func (c *Certificate) SetInvalidityDate(value time.Time) {
+	c.InvalidityDate = value
+}
+
+func (c Certificate) GetInvalidityDate() time.Time {
+	return c.InvalidityDate
+}
+
+func (c *Certificate) SetRevokedBy(value string) {
+	c.RevokedBy = value
+}
+
+func (c Certificate) GetRevokedBy() string {
+	return c.RevokedBy
+}
+
 func (c Certificate) GetStatus() int {
 	return c.Status
 }
 
 func (c *Certificate) SetActive() {
-	c.Status = STATUS_ACTIVE
+	c.setStatus(STATUS_ACTIVE)
 }
 
 func (c *Certificate) SetWithdrawn() {
-	c.Status = STATUS_WITHDRAWN
+	c.setStatus(STATUS_WITHDRAWN)
+}
+
+func (c *Certificate) SetSuspended() {
+	c.setStatus(STATUS_SUSPENDED)
 }
 
 func (c *Certificate) SetNotActive() {
-	c.Status = STATUS_NOT_ACTIVE
+	c.setStatus(STATUS_NOT_ACTIVE)
+}
+
+// setStatus changes the status and records the change in the history.
+// The status of records stored before the history was kept is recorded as of their creation first.
+func (c *Certificate) setStatus(status int) {
+	if len(c.History) > 0 && c.Status == status {
+		return
+	}
+	if len(c.History) == 0 && c.Status != status && !c.CreationDateTime.IsZero() {
+		c.History = append(c.History, StatusChange{Status: c.Status, DateTime: c.CreationDateTime})
+	}
+	c.Status = status
+	c.History = append(c.History, StatusChange{Status: status, DateTime: time.Now()})
+}
+
+func (c Certificate) GetHistory() []StatusChange {
+	return c.History
+}
+
+// StatusAt returns the status the certificate had at the moment, false means it was not issued yet.
+// Records stored before the history was kept only know the withdrawal date besides the current status.
+func (c Certificate) StatusAt(t time.Time) (int, bool) {
+	if t.Before(c.CreationDateTime) {
+		return 0, false
+	}
+	if len(c.History) == 0 {
+		if c.Status == STATUS_WITHDRAWN && t.Before(c.WithdrawalDateTime) {
+			return STATUS_ACTIVE, true
+		}
+		return c.Status, true
+	}
+
+	status := c.History[0].Status
+	for _, change := range c.History {
+		if change.DateTime.After(t) {
+			break
+		}
+		status = change.Status
+	}
+	return status, true
 }
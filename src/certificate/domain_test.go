@@ -0,0 +1,156 @@
+package certificate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatusAt(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	hours := func(n int) time.Time {
+		return created.Add(time.Duration(n) * time.Hour)
+	}
+
+	tests := []struct {
+		name        string
+		certificate Certificate
+		at          time.Time
+		status      int
+		issued      bool
+	}{
+		{
+			name:        "before issuance",
+			certificate: Certificate{CreationDateTime: created, Status: STATUS_ACTIVE, History: []StatusChange{{STATUS_ACTIVE, created}}},
+			at:          hours(-1),
+			issued:      false,
+		},
+		{
+			name:        "issued",
+			certificate: Certificate{CreationDateTime: created, Status: STATUS_ACTIVE, History: []StatusChange{{STATUS_ACTIVE, created}}},
+			at:          hours(1),
+			status:      STATUS_ACTIVE,
+			issued:      true,
+		},
+		{
+			name: "superseded, before",
+			certificate: Certificate{CreationDateTime: created, Status: STATUS_NOT_ACTIVE, History: []StatusChange{
+				{STATUS_ACTIVE, created}, {STATUS_NOT_ACTIVE, hours(24)},
+			}},
+			at:     hours(1),
+			status: STATUS_ACTIVE,
+			issued: true,
+		},
+		{
+			name: "superseded, after",
+			certificate: Certificate{CreationDateTime: created, Status: STATUS_NOT_ACTIVE, History: []StatusChange{
+				{STATUS_ACTIVE, created}, {STATUS_NOT_ACTIVE, hours(24)},
+			}},
+			at:     hours(25),
+			status: STATUS_NOT_ACTIVE,
+			issued: true,
+		},
+		{
+			name: "suspended",
+			certificate: Certificate{CreationDateTime: created, Status: STATUS_ACTIVE, History: []StatusChange{
+				{STATUS_ACTIVE, created}, {STATUS_SUSPENDED, hours(2)}, {STATUS_ACTIVE, hours(4)},
+			}},
+			at:     hours(3),
+			status: STATUS_SUSPENDED,
+			issued: true,
+		},
+		{
+			name: "reinstated",
+			certificate: Certificate{CreationDateTime: created, Status: STATUS_ACTIVE, History: []StatusChange{
+				{STATUS_ACTIVE, created}, {STATUS_SUSPENDED, hours(2)}, {STATUS_ACTIVE, hours(4)},
+			}},
+			at:     hours(5),
+			status: STATUS_ACTIVE,
+			issued: true,
+		},
+		{
+			name: "withdrawn, at the change",
+			certificate: Certificate{CreationDateTime: created, Status: STATUS_WITHDRAWN, History: []StatusChange{
+				{STATUS_ACTIVE, created}, {STATUS_WITHDRAWN, hours(2)},
+			}},
+			at:     hours(2),
+			status: STATUS_WITHDRAWN,
+			issued: true,
+		},
+		{
+			name: "withdrawn, before",
+			certificate: Certificate{CreationDateTime: created, Status: STATUS_WITHDRAWN, History: []StatusChange{
+				{STATUS_ACTIVE, created}, {STATUS_WITHDRAWN, hours(2)},
+			}},
+			at:     hours(1),
+			status: STATUS_ACTIVE,
+			issued: true,
+		},
+		{
+			name:        "legacy active",
+			certificate: Certificate{CreationDateTime: created, Status: STATUS_ACTIVE},
+			at:          hours(1),
+			status:      STATUS_ACTIVE,
+			issued:      true,
+		},
+		{
+			name:        "legacy withdrawn, before withdrawal",
+			certificate: Certificate{CreationDateTime: created, Status: STATUS_WITHDRAWN, WithdrawalDateTime: hours(2)},
+			at:          hours(1),
+			status:      STATUS_ACTIVE,
+			issued:      true,
+		},
+		{
+			name:        "legacy withdrawn, after withdrawal",
+			certificate: Certificate{CreationDateTime: created, Status: STATUS_WITHDRAWN, WithdrawalDateTime: hours(2)},
+			at:          hours(3),
+			status:      STATUS_WITHDRAWN,
+			issued:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, issued := tt.certificate.StatusAt(tt.at)
+			if issued != tt.issued || (issued && status != tt.status) {
+				t.Fatalf("StatusAt = %s, %t, expected %s, %t", StatusName(status), issued, StatusName(tt.status), tt.issued)
+			}
+		})
+	}
+}
+
+func TestSetStatusHistory(t *testing.T) {
+	created := time.Now().Add(-24 * time.Hour)
+
+	t.Run("new record", func(t *testing.T) {
+		c := new(Certificate)
+		c.SetActive()
+		c.SetActive()
+		c.SetSuspended()
+		history := c.GetHistory()
+		if len(history) != 2 || history[0].Status != STATUS_ACTIVE || history[1].Status != STATUS_SUSPENDED {
+			t.Fatalf("unexpected history %v", history)
+		}
+	})
+
+	t.Run("legacy record", func(t *testing.T) {
+		// stored before the history was kept, the current status is recorded as of the creation first
+		c := &Certificate{CreationDateTime: created, Status: STATUS_ACTIVE}
+		before := time.Now()
+		c.SetWithdrawn()
+		history := c.GetHistory()
+		if len(history) != 2 {
+			t.Fatalf("unexpected history %v", history)
+		}
+		if history[0].Status != STATUS_ACTIVE || !history[0].DateTime.Equal(created) {
+			t.Fatalf("legacy status recorded as %v", history[0])
+		}
+		if history[1].Status != STATUS_WITHDRAWN || history[1].DateTime.Before(before) {
+			t.Fatalf("change recorded as %v", history[1])
+		}
+		if status, _ := c.StatusAt(created.Add(time.Hour)); status != STATUS_ACTIVE {
+			t.Fatalf("status before the change is %s", StatusName(status))
+		}
+		if status, _ := c.StatusAt(time.Now()); status != STATUS_WITHDRAWN {
+			t.Fatalf("status after the change is %s", StatusName(status))
+		}
+	})
+}
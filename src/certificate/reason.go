@@ -0,0 +1,67 @@
+package certificate
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Revocation reason codes of RFC 5280 section 5.3.1
+const (
+	REASON_UNSPECIFIED            = 0
+	REASON_KEY_COMPROMISE         = 1
+	REASON_CA_COMPROMISE          = 2
+	REASON_AFFILIATION_CHANGED    = 3
+	REASON_SUPERSEDED             = 4
+	REASON_CESSATION_OF_OPERATION = 5
+	REASON_CERTIFICATE_HOLD       = 6
+	REASON_REMOVE_FROM_CRL        = 8
+	REASON_PRIVILEGE_WITHDRAWN    = 9
+	REASON_AA_COMPROMISE          = 10
+)
+
+var reasonNames = map[int]string{
+	REASON_UNSPECIFIED:            "unspecified",
+	REASON_KEY_COMPROMISE:         "keyCompromise",
+	REASON_CA_COMPROMISE:          "cACompromise",
+	REASON_AFFILIATION_CHANGED:    "affiliationChanged",
+	REASON_SUPERSEDED:             "superseded",
+	REASON_CESSATION_OF_OPERATION: "cessationOfOperation",
+	REASON_CERTIFICATE_HOLD:       "certificateHold",
+	REASON_REMOVE_FROM_CRL:        "removeFromCRL",
+	REASON_PRIVILEGE_WITHDRAWN:    "privilegeWithdrawn",
+	REASON_AA_COMPROMISE:          "aACompromise",
+}
+
+// ReasonName returns the RFC 5280 name of the reason code
+func ReasonName(reason int) string {
+	if name, ok := reasonNames[reason]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", reason)
+}
+
+// ParseReason returns the reason code of the RFC 5280 name, an empty name is unspecified
+func ParseReason(name string) (int, error) {
+	if name == "" {
+		return REASON_UNSPECIFIED, nil
+	}
+	for reason, n := range reasonNames {
+		if n == name {
+			return reason, nil
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("Unknown revocation reason %s", name))
+}
+
+// ParseWithdrawalReason returns the reason code of a permanent withdrawal. certificateHold is reachable only
+// by suspending the certificate and removeFromCRL is valid only in delta CRLs, both are rejected.
+func ParseWithdrawalReason(name string) (int, error) {
+	reason, err := ParseReason(name)
+	if err != nil {
+		return 0, err
+	}
+	if reason == REASON_CERTIFICATE_HOLD || reason == REASON_REMOVE_FROM_CRL {
+		return 0, errors.New(fmt.Sprintf("Revocation reason %s is not allowed for withdrawal", name))
+	}
+	return reason, nil
+}
@@ -0,0 +1,27 @@
+package certificate
+
+import "testing"
+
+func TestParseWithdrawalReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason int
+		valid  bool
+	}{
+		{"", REASON_UNSPECIFIED, true},
+		{"keyCompromise", REASON_KEY_COMPROMISE, true},
+		{"privilegeWithdrawn", REASON_PRIVILEGE_WITHDRAWN, true},
+		{"certificateHold", 0, false},
+		{"removeFromCRL", 0, false},
+		{"stolen", 0, false},
+	}
+	for _, tt := range tests {
+		reason, err := ParseWithdrawalReason(tt.name)
+		if tt.valid && (err != nil || reason != tt.reason) {
+			t.Errorf("ParseWithdrawalReason(%q) = %d, %v, expected %d", tt.name, reason, err, tt.reason)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("ParseWithdrawalReason(%q) = %d, expected an error", tt.name, reason)
+		}
+	}
+}
@@ -7,5 +7,8 @@ type Repository interface {
 	Find(serial big.Int) (*Certificate, error)
 	FindAll() []*Certificate
 	FindExpired() []*Certificate
+	FindByStatus(status int) []*Certificate
+	FindByUid(uid string) []*Certificate
+	FindByDid(did string) []*Certificate
 	FindByGidAndDidAndStatus(gid string, did string, status int) []*Certificate
 }
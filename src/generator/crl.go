@@ -0,0 +1,100 @@
+package generator
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var (
+	oidReasonCode     = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidInvalidityDate = asn1.ObjectIdentifier{2, 5, 29, 24}
+)
+
+// Revocation is a withdrawn certificate to list in the CRL
+type Revocation struct {
+	Certificate string
+	RevokedAt   time.Time
+	// Reason is the RFC 5280 revocation reason code
+	Reason         int
+	InvalidityDate time.Time
+}
+
+// CreateCRL returns the DER encoded CRL of the CA with the issuer id listing the revoked certificates it issued,
+// the signing CA is used when the id is empty. Revocations of certificates issued by other CAs are skipped.
+func (g *CryptoTLS) CreateCRL(issuer string, revocations []Revocation, number *big.Int, thisUpdate time.Time, nextUpdate time.Time) ([]byte, error) {
+	ca, err := g.findIssuer(issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked []pkix.RevokedCertificate
+	for _, revocation := range revocations {
+		bcrt, _ := pem.Decode([]byte(revocation.Certificate))
+		if bcrt == nil {
+			continue
+		}
+		crt, err := x509.ParseCertificate(bcrt.Bytes)
+		if err != nil || !issuedBy(crt, ca.crt) {
+			continue
+		}
+		entry := pkix.RevokedCertificate{
+			SerialNumber:   crt.SerialNumber,
+			RevocationTime: revocation.RevokedAt.UTC(),
+		}
+		// the unspecified reason should not be encoded
+		if revocation.Reason != 0 {
+			value, err := asn1.Marshal(asn1.Enumerated(revocation.Reason))
+			if err != nil {
+				return nil, err
+			}
+			entry.Extensions = append(entry.Extensions, pkix.Extension{Id: oidReasonCode, Value: value})
+		}
+		if !revocation.InvalidityDate.IsZero() {
+			value, err := asn1.MarshalWithParams(revocation.InvalidityDate.UTC(), "generalized")
+			if err != nil {
+				return nil, err
+			}
+			entry.Extensions = append(entry.Extensions, pkix.Extension{Id: oidInvalidityDate, Value: value})
+		}
+		revoked = append(revoked, entry)
+	}
+
+	template := &x509.RevocationList{
+		SignatureAlgorithm:  signatureAlgorithm(ca.key.Public()),
+		RevokedCertificates: revoked,
+		Number:              number,
+		ThisUpdate:          thisUpdate,
+		NextUpdate:          nextUpdate,
+	}
+	crl, err := x509.CreateRevocationList(rand.Reader, template, ca.crt, ca.key)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to generate CRL: %s", err))
+	}
+	return crl, nil
+}
+
+// IssuedBy reports whether the PEM encoded certificate was issued by the CA with the issuer id
+func (g *CryptoTLS) IssuedBy(content string, issuer string) bool {
+	ca, err := g.findIssuer(issuer)
+	if err != nil {
+		return false
+	}
+	crt, err := parseCertificate(content)
+	return err == nil && issuedBy(crt, ca.crt)
+}
+
+// issuedBy reports whether the certificate names the CA as its issuer
+func issuedBy(crt *x509.Certificate, ca *x509.Certificate) bool {
+	if len(crt.AuthorityKeyId) > 0 && len(ca.SubjectKeyId) > 0 {
+		return bytes.Equal(crt.AuthorityKeyId, ca.SubjectKeyId)
+	}
+	return bytes.Equal(crt.RawIssuer, ca.RawSubject)
+}
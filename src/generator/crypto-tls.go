@@ -1,7 +1,7 @@
 package generator
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"fmt"
 	"crypto/rand"
 	"errors"
@@ -11,41 +11,141 @@ import (
 	"encoding/pem"
 	"time"
 	"encoding/asn1"
-	"regexp"
-	"strings"
+	"sync"
 )
 
-var oid = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 2}
-
 type CryptoTLS struct {
 	DefaultSubject Subject
 	RsaBits        int
+	KeyAlgorithm   string
 	DefaultTTL     int
-	rootCACrt      *x509.Certificate
-	rootCAKey      *rsa.PrivateKey
+	SanPolicy      SanPolicy
+	Profiles       map[string]Profile
+	DefaultProfile string
+	Pkcs12Legacy   bool
+	KeyEncryption  KeyEncryption
+	IdentityOid    asn1.ObjectIdentifier
+	// CRLDistributionPoints are embedded into issued certificates with the id of the issuing CA appended
+	CRLDistributionPoints []string
+	// OCSPServers are embedded into issued certificates as authority information access
+	OCSPServers []string
+	// OCSPResponderTTL is the validity of generated OCSP responder certificates
+	OCSPResponderTTL time.Duration
+	rootCACrt        *x509.Certificate
+	rootCAKey        crypto.Signer
+	intermediates    []*issuer
+	activeCA         *issuer
+	trustedRoots     []trustedRoot
+	crossCerts       []*x509.Certificate
+	ocspMutex        sync.Mutex
+	ocspResponders   map[string]*ocspResponder
 }
 
-func (g *CryptoTLS) LoadRootCA(crt []byte, key [] byte) error {
+// LoadRootCA sets the root certificate and the signer of its private key.
+// The key may be nil when the root is kept offline and an intermediate CA signs certificates.
+func (g *CryptoTLS) LoadRootCA(crt []byte, key crypto.Signer) error {
 	var err error
 	bcrt, _ := pem.Decode(crt)
+	if bcrt == nil {
+		return errors.New("Failed to parse root certificate: no PEM data found")
+	}
 	if g.rootCACrt, err = x509.ParseCertificate(bcrt.Bytes); err != nil {
 		return errors.New(fmt.Sprintf("Failed to parse root certificate: %s", err.Error()))
 	}
-	bkey, _ := pem.Decode(key)
-	if g.rootCAKey, err = x509.ParsePKCS1PrivateKey(bkey.Bytes); err != nil {
-		return errors.New(fmt.Sprintf("Failed to parse root certificate private key: %s", err.Error()))
+	if key == nil {
+		return nil
 	}
+	if !publicKeysEqual(key.Public(), g.rootCACrt.PublicKey) {
+		return errors.New("Root certificate private key does not match the root certificate public key")
+	}
+	g.rootCAKey = key
 	return nil
 }
 
 func (g *CryptoTLS) Generate(options Options) (*CertificateDTO, error) {
 	var err error
 	// First of all gen new private key
-	newCrtPrivateKey, _ := rsa.GenerateKey(rand.Reader, g.RsaBits)
+	algorithm := g.KeyAlgorithm
+	if options.KeyAlgorithm() != "" {
+		algorithm = options.KeyAlgorithm()
+	}
+	newCrtPrivateKey, err := generateKey(algorithm, g.RsaBits)
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("Failed to generate private key: %s", err))
 	}
 
+	dto, err := g.issue(newCrtPrivateKey.Public(), options)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkey *pem.Block
+	if options.Password() != "" {
+		pkey, err = g.KeyEncryption.encrypt(newCrtPrivateKey, options.Password())
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to enctypt certificate key with password: %s", err))
+		}
+	} else {
+		pkey, err = marshalPrivateKey(newCrtPrivateKey)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to encode certificate key: %s", err))
+		}
+	}
+	dto.privateKey = string(pem.EncodeToMemory(pkey))
+
+	return dto, nil
+}
+
+// Sign issues a certificate for the public key of a PKCS#10 request.
+// The request may be PEM or DER encoded, its subject and extensions are ignored
+// in favour of the server policy.
+func (g *CryptoTLS) Sign(request string, options Options) (*CertificateDTO, error) {
+	der := []byte(request)
+	if b, _ := pem.Decode([]byte(request)); b != nil {
+		if b.Type != "CERTIFICATE REQUEST" && b.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, errors.New(fmt.Sprintf("Failed to parse CSR: unexpected PEM block %s", b.Type))
+		}
+		der = b.Bytes
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse CSR: %s", err))
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to verify CSR signature: %s", err))
+	}
+
+	// the requested alternative names are used unless the caller gives its own
+	if len(options.SubjectAltNames()) == 0 {
+		options.SetSubjectAltNames(subjectAltNames(csr.DNSNames, csr.IPAddresses, csr.URIs, csr.EmailAddresses))
+	}
+
+	return g.issue(csr.PublicKey, options)
+}
+
+// issue signs a certificate for the given public key with the active issuing CA
+func (g *CryptoTLS) issue(publicKey crypto.PublicKey, options Options) (*CertificateDTO, error) {
+	var err error
+	if signatureAlgorithm(publicKey) == x509.UnknownSignatureAlgorithm {
+		return nil, errors.New("Unsupported public key type")
+	}
+	for _, san := range options.SubjectAltNames() {
+		if err = g.SanPolicy.Check(san); err != nil {
+			return nil, err
+		}
+	}
+
+	profile, err := g.profile(options.Profile())
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := g.signingCA()
+	if err != nil {
+		return nil, err
+	}
+
 	//then gen certificate serial
 	genSerial := func() (*big.Int, error) {
 		serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
@@ -57,26 +157,6 @@ func (g *CryptoTLS) Generate(options Options) (*CertificateDTO, error) {
 		return nil, errors.New(fmt.Sprintf("Failed to generate serial number: %s", err))
 	}
 
-	// now we need to create certificate request
-	tcsr := x509.CertificateRequest{
-		Subject: pkix.Name{
-			Country:            []string{g.DefaultSubject.Country},
-			Organization:       []string{g.DefaultSubject.Organization},
-			OrganizationalUnit: []string{g.DefaultSubject.OrganizationalUnit},
-			Locality:           []string{},
-			Province:           []string{},
-			SerialNumber:       fmt.Sprintf("%s", serialNumber),
-			CommonName:         g.DefaultSubject.CommonName,
-		},
-		SignatureAlgorithm: x509.SHA256WithRSA,
-	}
-
-	bcsr, err := x509.CreateCertificateRequest(rand.Reader, &tcsr, newCrtPrivateKey)
-	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Failed to generate CSR: %s", err))
-	}
-	csr, _ := x509.ParseCertificateRequest(bcsr)
-
 	// resolve certificate dates
 	var notBefore time.Time
 	if len(options.ValidFrom()) == 0 {
@@ -94,117 +174,135 @@ func (g *CryptoTLS) Generate(options Options) (*CertificateDTO, error) {
 		if err != nil {
 			return nil, errors.New(fmt.Sprintf("Failed to parse expiration date: %s", err))
 		}
+	} else if profile.MaxTTL > 0 && notAfter.Sub(notBefore) > profile.MaxTTL {
+		// the default ttl is shortened to the profile limit
+		notAfter = notBefore.Add(profile.MaxTTL)
+	}
+
+	if err = profile.check(notBefore, notAfter, options.SubjectAltNames()); err != nil {
+		return nil, err
+	}
+
+	identityExt, err := identityExtension(g.identityOid(), options.Uid(), options.Did())
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to encode identity: %s", err))
 	}
 
 	// generate certificate with sign
 	cert := x509.Certificate{
-		Signature:          csr.Signature,
-		SignatureAlgorithm: csr.SignatureAlgorithm,
-
-		PublicKeyAlgorithm: csr.PublicKeyAlgorithm,
-		PublicKey:          csr.PublicKey,
-		Extensions:			csr.Extensions,
-		ExtraExtensions:    []pkix.Extension{
-			{Id: oid, Value: []byte(fmt.Sprintf("UID:%s", options.Uid()))},
-			{Id: oid, Value: []byte(fmt.Sprintf("DID:%s", options.Did()))},
+		SignatureAlgorithm: signatureAlgorithm(ca.key.Public()),
+
+		ExtraExtensions: []pkix.Extension{identityExt},
+		SerialNumber:    serialNumber,
+		Issuer:          ca.crt.Subject,
+		Subject: pkix.Name{
+			Country:            []string{g.DefaultSubject.Country},
+			Organization:       []string{g.DefaultSubject.Organization},
+			OrganizationalUnit: []string{g.DefaultSubject.OrganizationalUnit},
+			Locality:           []string{},
+			Province:           []string{},
+			SerialNumber:       fmt.Sprintf("%s", serialNumber),
+			CommonName:         g.DefaultSubject.CommonName,
 		},
-		SerialNumber: serialNumber,
-		Issuer:       g.rootCACrt.Subject,
-		Subject:      csr.Subject,
-		NotBefore:    notBefore,
-		NotAfter:     notAfter,
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		IsCA: 		  true,
-		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+
+		CRLDistributionPoints: g.crlDistributionPoints(ca),
+		OCSPServer:            g.OCSPServers,
 	}
+	profile.apply(&cert)
 
-	ck, err := x509.CreateCertificate(rand.Reader, &cert, g.rootCACrt, csr.PublicKey, g.rootCAKey)
-	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Failed to generate certificate: %s", err))
+	if err = applySubjectAltNames(&cert, options.SubjectAltNames()); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to set subject alternative names: %s", err))
 	}
+	cert.URIs = append(cert.URIs, identityURI(options.Uid(), options.Did()))
 
-	var pkey, crt *pem.Block
-	if options.Password() != "" {
-		pkey, err = x509.EncryptPEMBlock(
-			rand.Reader, "RSA PRIVATE KEY",
-			x509.MarshalPKCS1PrivateKey(newCrtPrivateKey), []byte(options.Password()), x509.PEMCipherAES128)
-		if err != nil {
-			return nil, errors.New(fmt.Sprintf("Failed to enctypt certificate key with password: %s", err))
-		}
-	} else {
-		pkey = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(newCrtPrivateKey)}
+	ck, err := x509.CreateCertificate(rand.Reader, &cert, ca.crt, publicKey, ca.key)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to generate certificate: %s", err))
 	}
 
-	crt = &pem.Block{Type: "CERTIFICATE", Bytes: ck}
+	crt := &pem.Block{Type: "CERTIFICATE", Bytes: ck}
 
 	return &CertificateDTO{
 		certificate: string(pem.EncodeToMemory(crt)),
-		privateKey:  string(pem.EncodeToMemory(pkey)),
 		notAfter:    notAfter,
 		notBefore:   notBefore,
 		serial:      serialNumber.String(),
 	}, nil
 }
 
-func (g *CryptoTLS) Validate(content string, intermediate string) (bool, error) {
-
-	opts := x509.VerifyOptions{
-		Roots: x509.NewCertPool(),
-		Intermediates: x509.NewCertPool(),
-
-		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+// IsCAProfile tells whether the named profile issues CA certificates, an empty name means the default one
+func (g *CryptoTLS) IsCAProfile(name string) (bool, error) {
+	profile, err := g.profile(name)
+	if err != nil {
+		return false, err
 	}
-	opts.CurrentTime = time.Now()
-	opts.Roots.AddCert(g.rootCACrt)
+	return profile.IsCA, nil
+}
 
-	if intermediate != "" {
-		bcrt, _ := pem.Decode([]byte(intermediate))
-		var pcrt *x509.Certificate
-		var err error
-		if pcrt, err = x509.ParseCertificate(bcrt.Bytes); err != nil {
-			return false, errors.New(fmt.Sprintf("Failed to parse certificate: %s", err.Error()))
-		}
-		opts.Intermediates.AddCert(pcrt)
+// profile resolves the named profile, an empty name means the default one
+func (g *CryptoTLS) profile(name string) (Profile, error) {
+	if name == "" {
+		name = g.DefaultProfile
 	}
-
-	var crt *x509.Certificate
-	var err error
-	bcrt, _ := pem.Decode([]byte(content))
-	if crt, err = x509.ParseCertificate(bcrt.Bytes); err != nil {
-		return false, errors.New(fmt.Sprintf("Failed to parse certificate: %s", err.Error()))
+	if name == "" {
+		name = PROFILE_LEAF
 	}
-
-	_ , err = crt.Verify(opts)
-	if err != nil {
-		return false, errors.New(fmt.Sprintf("Failed to validate certificate: %s", err.Error()))
+	profiles := g.Profiles
+	if profiles == nil {
+		profiles = DefaultProfiles()
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return Profile{}, errors.New(fmt.Sprintf("Unknown certificate profile %s", name))
 	}
+	return profile, nil
+}
 
+func (g *CryptoTLS) Validate(content string, intermediate string) (bool, error) {
+	if _, err := g.VerifyChain(content, intermediate, time.Now()); err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
 func (g *CryptoTLS) ParseUidDid(content string) (string, string, error) {
 	bcrt, _ := pem.Decode([]byte(content))
-	var crt *x509.Certificate
-	var err error
-	if crt, err = x509.ParseCertificate(bcrt.Bytes); err != nil {
+	if bcrt == nil {
+		return "", "", errors.New("Failed to parse certificate: no PEM data found")
+	}
+	var crt rawCertificate
+	if _, err := asn1.Unmarshal(bcrt.Bytes, &crt); err != nil {
 		return "", "", errors.New(fmt.Sprintf("Failed to parse certificate: %s", err.Error()))
 	}
 
-	var uid, did string
-	r, _ := regexp.Compile(`^(UID|DID):(.+$)`)
-	for _, extension := range crt.Extensions {
-		if extension.Id.Equal(oid) && r.Match(extension.Value) {
-			values := strings.Split(string(extension.Value), ":")
-			if values[0] == "UID" {
-				uid = values[1]
-			}
-			if values[0] == "DID" {
-				did = values[1]
-			}
-		}
+	uid, did := parseIdentity(crt.TBSCertificate.Extensions, g.identityOid())
+	return uid, did, nil
+}
+
+// ParseSerial returns the serial number of the certificate
+func (g *CryptoTLS) ParseSerial(content string) (*big.Int, error) {
+	bcrt, _ := pem.Decode([]byte(content))
+	if bcrt == nil {
+		return nil, errors.New("Failed to parse certificate: no PEM data found")
+	}
+	var crt rawCertificate
+	if _, err := asn1.Unmarshal(bcrt.Bytes, &crt); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse certificate: %s", err.Error()))
 	}
+	serial := new(big.Int)
+	if _, err := asn1.Unmarshal(crt.TBSCertificate.SerialNumber.FullBytes, &serial); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse certificate serial number: %s", err.Error()))
+	}
+	return serial, nil
+}
 
-	return uid, did, nil
+func (g *CryptoTLS) identityOid() asn1.ObjectIdentifier {
+	if len(g.IdentityOid) == 0 {
+		return DefaultIdentityOid
+	}
+	return g.IdentityOid
 }
 
 func (g *CryptoTLS) ParseDates(content string) (*time.Time, *time.Time, error) {
@@ -216,4 +314,17 @@ func (g *CryptoTLS) ParseDates(content string) (*time.Time, *time.Time, error) {
 	}
 
 	return &crt.NotAfter, &crt.NotBefore, nil
-}
\ No newline at end of file
+}
+
+func (g *CryptoTLS) ParseSubjectAltNames(content string) ([]SubjectAltName, error) {
+	bcrt, _ := pem.Decode([]byte(content))
+	if bcrt == nil {
+		return nil, errors.New("Failed to parse certificate: no PEM data found")
+	}
+	crt, err := x509.ParseCertificate(bcrt.Bytes)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse certificate: %s", err.Error()))
+	}
+
+	return subjectAltNames(crt.DNSNames, crt.IPAddresses, crt.URIs, crt.EmailAddresses), nil
+}
@@ -0,0 +1,80 @@
+package generator
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/youmark/pkcs8"
+)
+
+const (
+	KDF_PBKDF2 = "pbkdf2"
+	KDF_SCRYPT = "scrypt"
+
+	DEFAULT_PBKDF2_ITERATIONS = 600000
+	DEFAULT_SCRYPT_COST       = 32768
+)
+
+// KeyEncryption configures how password protected private keys are encrypted.
+// Keys are stored as PKCS#8 with PBES2 and AES-256, the legacy OpenSSL PEM
+// encryption is only used when LegacyPEM is set.
+type KeyEncryption struct {
+	KDF        string
+	Iterations int
+	ScryptCost int
+	LegacyPEM  bool
+}
+
+// IsSupportedKDF reports whether the key derivation function can be used for key encryption
+func IsSupportedKDF(kdf string) bool {
+	return kdf == "" || kdf == KDF_PBKDF2 || kdf == KDF_SCRYPT
+}
+
+func (e KeyEncryption) opts() (*pkcs8.Opts, error) {
+	switch e.KDF {
+	case "", KDF_PBKDF2:
+		iterations := e.Iterations
+		if iterations <= 0 {
+			iterations = DEFAULT_PBKDF2_ITERATIONS
+		}
+		return &pkcs8.Opts{
+			Cipher:  pkcs8.AES256CBC,
+			KDFOpts: pkcs8.PBKDF2Opts{SaltSize: 16, IterationCount: iterations, HMACHash: crypto.SHA256},
+		}, nil
+	case KDF_SCRYPT:
+		cost := e.ScryptCost
+		if cost <= 0 {
+			cost = DEFAULT_SCRYPT_COST
+		}
+		return &pkcs8.Opts{
+			Cipher:  pkcs8.AES256CBC,
+			KDFOpts: pkcs8.ScryptOpts{SaltSize: 16, CostParameter: cost, BlockSize: 8, ParallelizationParameter: 1},
+		}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unsupported key derivation function: %s", e.KDF))
+}
+
+// encrypt protects the private key with the password
+func (e KeyEncryption) encrypt(key crypto.Signer, password string) (*pem.Block, error) {
+	if e.LegacyPEM {
+		block, err := marshalPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(password), x509.PEMCipherAES128)
+	}
+
+	opts, err := e.opts()
+	if err != nil {
+		return nil, err
+	}
+	der, err := pkcs8.MarshalPrivateKey(key, []byte(password), opts)
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}, nil
+}
@@ -0,0 +1,101 @@
+package generator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/kuai6/nc-crtmgr/src/signer"
+)
+
+// testGenerator returns a generator with a self signed ECDSA root
+func testGenerator(t *testing.T) *CryptoTLS {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := &CryptoTLS{DefaultTTL: 1, KeyAlgorithm: KEY_ALGORITHM_ECDSA_P256, DefaultSubject: Subject{CommonName: "test"}}
+	if err := g.LoadRootCA(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestKeyEncryptionRoundTrip(t *testing.T) {
+	g := testGenerator(t)
+
+	tests := []struct {
+		name       string
+		encryption KeyEncryption
+		blockType  string
+	}{
+		{"pbkdf2", KeyEncryption{KDF: KDF_PBKDF2, Iterations: 1000}, "ENCRYPTED PRIVATE KEY"},
+		{"scrypt", KeyEncryption{KDF: KDF_SCRYPT, ScryptCost: 1024}, "ENCRYPTED PRIVATE KEY"},
+		{"legacy pem", KeyEncryption{LegacyPEM: true}, "EC PRIVATE KEY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g.KeyEncryption = tt.encryption
+			options := Options{}
+			options.SetUid("uid")
+			options.SetDid("did")
+			options.SetPassword("secret")
+
+			dto, err := g.Generate(options)
+			if err != nil {
+				t.Fatalf("Generate: %s", err)
+			}
+			block, _ := pem.Decode([]byte(dto.PrivateKey()))
+			if block == nil || block.Type != tt.blockType {
+				t.Fatalf("Generate returned private key %q, expected %s block", dto.PrivateKey(), tt.blockType)
+			}
+
+			key, err := signer.ParsePrivateKey([]byte(dto.PrivateKey()), []byte("secret"))
+			if err != nil {
+				t.Fatalf("ParsePrivateKey: %s", err)
+			}
+			bcrt, _ := pem.Decode([]byte(dto.Certificate()))
+			crt, err := x509.ParseCertificate(bcrt.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !publicKeysEqual(key.Public(), crt.PublicKey) {
+				t.Fatal("decrypted key does not match the certificate")
+			}
+
+			if _, err := signer.ParsePrivateKey([]byte(dto.PrivateKey()), []byte("wrong")); err == nil {
+				t.Fatal("ParsePrivateKey accepted a wrong passphrase")
+			}
+		})
+	}
+}
+
+func TestKeyEncryptionUnsupportedKDF(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (KeyEncryption{KDF: "argon2"}).encrypt(key, "secret"); err == nil {
+		t.Fatal("encrypt accepted an unsupported key derivation function")
+	}
+}
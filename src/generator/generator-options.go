@@ -1,11 +1,14 @@
 package generator
 
 type Options struct {
-	validFrom string
-	validFor  string
-	password  string
-	uid		  string
-	did       string
+	validFrom    string
+	validFor     string
+	password     string
+	uid          string
+	did          string
+	keyAlgorithm string
+	sans         []SubjectAltName
+	profile      string
 }
 
 func (o *Options) SetValidFrom(value string) {
@@ -47,3 +50,27 @@ func (o *Options) SetDid(value string) {
 func (o Options) Did() string {
 	return o.did
 }
+
+func (o *Options) SetKeyAlgorithm(value string) {
+	o.keyAlgorithm = value
+}
+
+func (o Options) KeyAlgorithm() string {
+	return o.keyAlgorithm
+}
+
+func (o *Options) SetSubjectAltNames(value []SubjectAltName) {
+	o.sans = value
+}
+
+func (o Options) SubjectAltNames() []SubjectAltName {
+	return o.sans
+}
+
+func (o *Options) SetProfile(value string) {
+	o.profile = value
+}
+
+func (o Options) Profile() string {
+	return o.profile
+}
@@ -1,6 +1,7 @@
 package generator
 
 import (
+	"math/big"
 	"time"
 )
 
@@ -15,9 +16,24 @@ type Subject struct {
 
 type Generator interface {
 	Generate(options Options) (*CertificateDTO, error)
+	Sign(request string, options Options) (*CertificateDTO, error)
+	Bundle(certificate string, privateKey string, password string) ([]byte, error)
 	Validate(content string, intermediate string) (bool, error)
+	VerifyChain(content string, intermediate string, at time.Time) ([]string, error)
+	VerifyPossession(content string, message []byte, signature []byte) error
+	IsCAProfile(name string) (bool, error)
+	Inspect(content string) (*CertificateInfo, error)
 	ParseUidDid(content string) (string, string, error)
+	ParseSerial(content string) (*big.Int, error)
 	ParseDates(content string) (*time.Time, *time.Time, error)
+	ParseSubjectAltNames(content string) ([]SubjectAltName, error)
+	Chain(content string) ([]string, error)
+	Issuers() []string
+	SigningIssuer() (string, error)
+	IssuedBy(content string, issuer string) bool
+	CreateCRL(issuer string, revocations []Revocation, number *big.Int, thisUpdate time.Time, nextUpdate time.Time) ([]byte, error)
+	ParseOCSPRequest(request []byte) (*big.Int, string, error)
+	CreateOCSPResponse(response OCSPResponse) ([]byte, error)
 }
 
 type CertificateDTO struct {
@@ -46,4 +62,4 @@ func (cd CertificateDTO) NotBefore() time.Time {
 
 func (cd CertificateDTO) Serial() string {
 	return cd.serial
-}
\ No newline at end of file
+}
@@ -0,0 +1,113 @@
+package generator
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// legacyIdentityOid is the PKCS#9 OID used by older releases for "UID:..." and "DID:..." extensions
+var legacyIdentityOid = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 2}
+
+// DefaultIdentityOid is a placeholder used when no OID is configured. The private enterprise number 99999
+// is not assigned to the project, installations should configure an OID under their own arc.
+var DefaultIdentityOid = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1, 1}
+
+const IDENTITY_URN_PREFIX = "urn:nc-crtmgr:identity:"
+
+// identity is the value of the identity extension
+//
+//	Identity ::= SEQUENCE {
+//	    uid UTF8String,
+//	    did UTF8String }
+type identity struct {
+	Uid string `asn1:"utf8"`
+	Did string `asn1:"utf8"`
+}
+
+// rawCertificate is enough of the certificate structure to read the extensions
+// of certificates rejected by x509.ParseCertificate, such as legacy ones with duplicate extensions
+type rawCertificate struct {
+	TBSCertificate     rawTBSCertificate
+	SignatureAlgorithm asn1.RawValue
+	SignatureValue     asn1.RawValue
+}
+
+type rawTBSCertificate struct {
+	Version            int `asn1:"optional,explicit,default:0,tag:0"`
+	SerialNumber       asn1.RawValue
+	SignatureAlgorithm asn1.RawValue
+	Issuer             asn1.RawValue
+	Validity           asn1.RawValue
+	Subject            asn1.RawValue
+	PublicKey          asn1.RawValue
+	UniqueId           asn1.BitString   `asn1:"optional,tag:1"`
+	SubjectUniqueId    asn1.BitString   `asn1:"optional,tag:2"`
+	Extensions         []pkix.Extension `asn1:"optional,explicit,tag:3"`
+}
+
+// ParseOID parses the dotted form of an object identifier
+func ParseOID(value string) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	for _, part := range strings.Split(value, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid object identifier %q", value))
+		}
+		oid = append(oid, n)
+	}
+	if len(oid) < 2 {
+		return nil, errors.New(fmt.Sprintf("Invalid object identifier %q", value))
+	}
+	return oid, nil
+}
+
+func identityExtension(oid asn1.ObjectIdentifier, uid string, did string) (pkix.Extension, error) {
+	value, err := asn1.Marshal(identity{Uid: uid, Did: did})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oid, Value: value}, nil
+}
+
+// identityURI formats the identity as urn:nc-crtmgr:identity:<uid>:<did> with escaped components
+func identityURI(uid string, did string) *url.URL {
+	return &url.URL{
+		Scheme: "urn",
+		Opaque: strings.TrimPrefix(IDENTITY_URN_PREFIX, "urn:") + url.QueryEscape(uid) + ":" + url.QueryEscape(did),
+	}
+}
+
+// parseIdentity reads uid and did from the identity extension and falls back to the legacy encoding
+func parseIdentity(extensions []pkix.Extension, oid asn1.ObjectIdentifier) (string, string) {
+	for _, extension := range extensions {
+		if extension.Id.Equal(oid) {
+			var id identity
+			if rest, err := asn1.Unmarshal(extension.Value, &id); err == nil && len(rest) == 0 {
+				return id.Uid, id.Did
+			}
+		}
+	}
+
+	var uid, did string
+	for _, extension := range extensions {
+		if !extension.Id.Equal(legacyIdentityOid) {
+			continue
+		}
+		values := strings.SplitN(string(extension.Value), ":", 2)
+		if len(values) != 2 || values[1] == "" {
+			continue
+		}
+		if values[0] == "UID" {
+			uid = values[1]
+		}
+		if values[0] == "DID" {
+			did = values[1]
+		}
+	}
+	return uid, did
+}
@@ -0,0 +1,106 @@
+package generator
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// CertificateInfo is the parsed content of a certificate
+type CertificateInfo struct {
+	Subject           string
+	Issuer            string
+	Serial            *big.Int
+	FingerprintSHA256 string
+	FingerprintSHA1   string
+	NotBefore         time.Time
+	NotAfter          time.Time
+	Uid               string
+	Did               string
+	Sans              []SubjectAltName
+}
+
+// Inspect parses the certificate into CertificateInfo
+func (g *CryptoTLS) Inspect(content string) (*CertificateInfo, error) {
+	crt, err := parseCertificate(content)
+	if err != nil {
+		return nil, err
+	}
+	sha256Sum := sha256.Sum256(crt.Raw)
+	sha1Sum := sha1.Sum(crt.Raw)
+	uid, did := parseIdentity(crt.Extensions, g.identityOid())
+
+	return &CertificateInfo{
+		Subject:           crt.Subject.String(),
+		Issuer:            crt.Issuer.String(),
+		Serial:            crt.SerialNumber,
+		FingerprintSHA256: hex.EncodeToString(sha256Sum[:]),
+		FingerprintSHA1:   hex.EncodeToString(sha1Sum[:]),
+		NotBefore:         crt.NotBefore,
+		NotAfter:          crt.NotAfter,
+		Uid:               uid,
+		Did:               did,
+		Sans:              subjectAltNames(crt.DNSNames, crt.IPAddresses, crt.URIs, crt.EmailAddresses),
+	}, nil
+}
+
+// VerifyChain verifies the certificate against the roots trusted at the given moment and returns the subjects
+// of the built chain from the certificate up to the root. The intermediate is optional, zero time means now.
+func (g *CryptoTLS) VerifyChain(content string, intermediate string, at time.Time) ([]string, error) {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	opts := x509.VerifyOptions{
+		Roots:         g.roots(at),
+		Intermediates: g.intermediatePool(at),
+
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	opts.CurrentTime = at
+
+	if intermediate != "" {
+		pcrt, err := parseCertificate(intermediate)
+		if err != nil {
+			return nil, err
+		}
+		opts.Intermediates.AddCert(pcrt)
+	}
+
+	crt, err := parseCertificate(content)
+	if err != nil {
+		return nil, err
+	}
+
+	chains, err := crt.Verify(opts)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to validate certificate: %s", err.Error()))
+	}
+	chain, err := g.trustedChain(chains, at)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to validate certificate: %s", err.Error()))
+	}
+
+	var subjects []string
+	for _, c := range chain {
+		subjects = append(subjects, c.Subject.String())
+	}
+	return subjects, nil
+}
+
+func parseCertificate(content string) (*x509.Certificate, error) {
+	bcrt, _ := pem.Decode([]byte(content))
+	if bcrt == nil {
+		return nil, errors.New("Failed to parse certificate: no PEM data found")
+	}
+	crt, err := x509.ParseCertificate(bcrt.Bytes)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse certificate: %s", err.Error()))
+	}
+	return crt, nil
+}
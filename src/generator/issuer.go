@@ -0,0 +1,214 @@
+package generator
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrUnknownIssuer = errors.New("Unknown issuer")
+
+// issuer is a CA able to sign certificates
+type issuer struct {
+	crt *x509.Certificate
+	key crypto.Signer
+	// chain holds the certificates between the issuer and the root
+	chain []*x509.Certificate
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var crts []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		crt, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		crts = append(crts, crt)
+	}
+	return crts, nil
+}
+
+// AddIntermediateCA registers an issuing intermediate CA with its chain up to the root.
+// The key may be nil for intermediates used only for validation, the active one signs new certificates.
+func (g *CryptoTLS) AddIntermediateCA(crt []byte, chain []byte, key crypto.Signer, active bool) error {
+	if g.rootCACrt == nil {
+		return errors.New("Root certificate must be loaded before intermediate CAs")
+	}
+	crts, err := parseCertificates(crt)
+	if err != nil || len(crts) == 0 {
+		return errors.New(fmt.Sprintf("Failed to parse intermediate certificate: %v", err))
+	}
+	chainCrts, err := parseCertificates(chain)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to parse intermediate certificate chain: %s", err))
+	}
+	ca := &issuer{crt: crts[0], key: key, chain: chainCrts}
+
+	if !ca.crt.IsCA {
+		return errors.New(fmt.Sprintf("Intermediate certificate %s is not a CA", ca.crt.Subject))
+	}
+	opts := x509.VerifyOptions{
+		Roots:         g.roots(time.Now()),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, c := range chainCrts {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err = ca.crt.Verify(opts); err != nil {
+		return errors.New(fmt.Sprintf("Intermediate certificate %s does not chain to the root: %s", ca.crt.Subject, err))
+	}
+
+	if key != nil && !publicKeysEqual(key.Public(), ca.crt.PublicKey) {
+		return errors.New(fmt.Sprintf("Private key does not match intermediate certificate %s", ca.crt.Subject))
+	}
+	if active {
+		if key == nil {
+			return errors.New(fmt.Sprintf("Active intermediate CA %s has no private key", ca.crt.Subject))
+		}
+		if g.activeCA != nil {
+			return errors.New("Only one intermediate CA may be active")
+		}
+		g.activeCA = ca
+	}
+	g.intermediates = append(g.intermediates, ca)
+	return nil
+}
+
+// signingCA returns the CA signing new certificates, the active intermediate or the root itself
+func (g *CryptoTLS) signingCA() (*issuer, error) {
+	if g.activeCA != nil {
+		return g.activeCA, nil
+	}
+	if g.rootCAKey != nil {
+		return &issuer{crt: g.rootCACrt, key: g.rootCAKey}, nil
+	}
+	return nil, errors.New("No signing CA is available, configure an active intermediate CA or the root key")
+}
+
+// issuerID identifies a CA by its subject key identifier, or by the hash of its public key when it has none
+func issuerID(crt *x509.Certificate) string {
+	if len(crt.SubjectKeyId) > 0 {
+		return hex.EncodeToString(crt.SubjectKeyId)
+	}
+	sum := sha1.Sum(crt.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// keyedCAs returns every CA with a private key, the root first and then the intermediates
+func (g *CryptoTLS) keyedCAs() []*issuer {
+	var cas []*issuer
+	if g.rootCAKey != nil {
+		cas = append(cas, &issuer{crt: g.rootCACrt, key: g.rootCAKey})
+	}
+	for _, ca := range g.intermediates {
+		if ca.key != nil {
+			cas = append(cas, ca)
+		}
+	}
+	return cas
+}
+
+// Issuers returns the ids of the CAs able to sign CRLs and OCSP responses
+func (g *CryptoTLS) Issuers() []string {
+	var ids []string
+	for _, ca := range g.keyedCAs() {
+		ids = append(ids, issuerID(ca.crt))
+	}
+	return ids
+}
+
+// findIssuer returns the CA with the id, the signing CA when the id is empty
+func (g *CryptoTLS) findIssuer(id string) (*issuer, error) {
+	if id == "" {
+		return g.signingCA()
+	}
+	for _, ca := range g.keyedCAs() {
+		if issuerID(ca.crt) == id {
+			return ca, nil
+		}
+	}
+	return nil, ErrUnknownIssuer
+}
+
+// SigningIssuer returns the id of the CA signing new certificates
+func (g *CryptoTLS) SigningIssuer() (string, error) {
+	ca, err := g.signingCA()
+	if err != nil {
+		return "", err
+	}
+	return issuerID(ca.crt), nil
+}
+
+// crlDistributionPoints returns the configured CRL URLs pointing to the CRL of the CA
+func (g *CryptoTLS) crlDistributionPoints(ca *issuer) []string {
+	var urls []string
+	for _, point := range g.CRLDistributionPoints {
+		urls = append(urls, strings.TrimSuffix(point, "/")+"/"+issuerID(ca.crt))
+	}
+	return urls
+}
+
+// intermediatePool returns the pool of the configured intermediates, their chains and the cross certificates.
+// Cross certificates of roots retired at the given time are left out.
+func (g *CryptoTLS) intermediatePool(at time.Time) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, c := range g.crossCerts {
+		if !g.retired(c, at) {
+			pool.AddCert(c)
+		}
+	}
+	for _, ca := range g.intermediates {
+		pool.AddCert(ca.crt)
+		for _, c := range ca.chain {
+			pool.AddCert(c)
+		}
+	}
+	return pool
+}
+
+// chainOf returns the CA certificates from the issuer of the certificate up to the root
+func (g *CryptoTLS) chainOf(crt *x509.Certificate) []*x509.Certificate {
+	for _, ca := range g.intermediates {
+		if crt.CheckSignatureFrom(ca.crt) == nil {
+			chain := append([]*x509.Certificate{ca.crt}, ca.chain...)
+			return append(chain, g.rootOf(chain[len(chain)-1]))
+		}
+	}
+	return []*x509.Certificate{g.rootOf(crt)}
+}
+
+// Chain returns PEM encoded CA certificates from the issuer of the certificate up to the root
+func (g *CryptoTLS) Chain(content string) ([]string, error) {
+	bcrt, _ := pem.Decode([]byte(content))
+	if bcrt == nil {
+		return nil, errors.New("Failed to parse certificate: no PEM data found")
+	}
+	crt, err := x509.ParseCertificate(bcrt.Bytes)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse certificate: %s", err.Error()))
+	}
+	return encodeCertificates(g.chainOf(crt)), nil
+}
+
+func encodeCertificates(crts []*x509.Certificate) []string {
+	var result []string
+	for _, c := range crts {
+		result = append(result, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})))
+	}
+	return result
+}
@@ -0,0 +1,91 @@
+package generator
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const (
+	KEY_ALGORITHM_RSA        = "rsa"
+	KEY_ALGORITHM_ECDSA_P256 = "ecdsa-p256"
+	KEY_ALGORITHM_ECDSA_P384 = "ecdsa-p384"
+	KEY_ALGORITHM_ED25519    = "ed25519"
+)
+
+// generateKey creates a new private key of the given algorithm.
+// An empty algorithm falls back to RSA.
+func generateKey(algorithm string, rsaBits int) (crypto.Signer, error) {
+	switch algorithm {
+	case "", KEY_ALGORITHM_RSA:
+		return rsa.GenerateKey(rand.Reader, rsaBits)
+	case KEY_ALGORITHM_ECDSA_P256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KEY_ALGORITHM_ECDSA_P384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KEY_ALGORITHM_ED25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, errors.New(fmt.Sprintf("Unsupported key algorithm: %s", algorithm))
+}
+
+// IsSupportedKeyAlgorithm reports whether the algorithm can be used for key generation
+func IsSupportedKeyAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case KEY_ALGORITHM_RSA, KEY_ALGORITHM_ECDSA_P256, KEY_ALGORITHM_ECDSA_P384, KEY_ALGORITHM_ED25519:
+		return true
+	}
+	return false
+}
+
+// signatureAlgorithm returns the signature algorithm used when signing with the key
+func signatureAlgorithm(key crypto.PublicKey) x509.SignatureAlgorithm {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P384() {
+			return x509.ECDSAWithSHA384
+		}
+		return x509.ECDSAWithSHA256
+	case ed25519.PublicKey:
+		return x509.PureEd25519
+	}
+	return x509.UnknownSignatureAlgorithm
+}
+
+// publicKeysEqual reports whether both public keys are the same
+func publicKeysEqual(a crypto.PublicKey, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+// marshalPrivateKey encodes the key into a PEM block.
+// RSA keys stay in PKCS#1 and ECDSA keys in SEC1 for compatibility, Ed25519 keys use PKCS#8.
+func marshalPrivateKey(key crypto.Signer) (*pem.Block, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	case *ecdsa.PrivateKey:
+		b, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}, nil
+	case ed25519.PrivateKey:
+		b, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: b}, nil
+	}
+	return nil, errors.New("Unsupported private key type")
+}
@@ -0,0 +1,216 @@
+package generator
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	OCSP_GOOD    = ocsp.Good
+	OCSP_REVOKED = ocsp.Revoked
+	OCSP_UNKNOWN = ocsp.Unknown
+)
+
+// ErrOCSPUnauthorized is returned for requests about certificates of another CA
+var ErrOCSPUnauthorized = errors.New("OCSP request is not for a certificate of a configured CA")
+
+// oidOCSPNoCheck tells relying parties not to check the revocation of the responder certificate
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// OCSPResponse is the status of a certificate to sign into an OCSP response
+type OCSPResponse struct {
+	// Issuer is the id of the CA the certificate was issued by
+	Issuer    string
+	Serial    *big.Int
+	Status    int
+	RevokedAt time.Time
+	// Reason is the RFC 5280 revocation reason code
+	Reason     int
+	ThisUpdate time.Time
+	NextUpdate time.Time
+}
+
+// ocspResponder is the delegated responder certificate issued by a CA
+type ocspResponder struct {
+	crt *x509.Certificate
+	key crypto.Signer
+	// generated responders are renewed before they expire
+	generated bool
+}
+
+// LoadOCSPResponder sets the delegated responder certificate of the CA that issued it for OCSP signing.
+// Responders are generated for the other CAs.
+func (g *CryptoTLS) LoadOCSPResponder(crt []byte, key crypto.Signer) error {
+	crts, err := parseCertificates(crt)
+	if err != nil || len(crts) == 0 {
+		return errors.New(fmt.Sprintf("Failed to parse OCSP responder certificate: %v", err))
+	}
+	responder := crts[0]
+	var ca *issuer
+	for _, c := range g.keyedCAs() {
+		if responder.CheckSignatureFrom(c.crt) == nil {
+			ca = c
+			break
+		}
+	}
+	if ca == nil {
+		return errors.New("OCSP responder certificate is not issued by a configured CA")
+	}
+	ocspSigning := false
+	for _, usage := range responder.ExtKeyUsage {
+		ocspSigning = ocspSigning || usage == x509.ExtKeyUsageOCSPSigning
+	}
+	if !ocspSigning {
+		return errors.New("OCSP responder certificate is not allowed to sign OCSP responses")
+	}
+	if key == nil || !publicKeysEqual(key.Public(), responder.PublicKey) {
+		return errors.New("Private key does not match OCSP responder certificate")
+	}
+	if time.Now().After(responder.NotAfter) {
+		return errors.New(fmt.Sprintf("OCSP responder certificate expired at %s", responder.NotAfter.Format(time.RFC3339)))
+	}
+
+	g.ocspMutex.Lock()
+	defer g.ocspMutex.Unlock()
+	if g.ocspResponders == nil {
+		g.ocspResponders = make(map[string]*ocspResponder)
+	}
+	g.ocspResponders[issuerID(ca.crt)] = &ocspResponder{crt: responder, key: key}
+	return nil
+}
+
+// responder returns the delegated responder of the CA, a new one is issued when none is loaded
+// or the generated one is half expired
+func (g *CryptoTLS) responder(ca *issuer) (*ocspResponder, error) {
+	g.ocspMutex.Lock()
+	defer g.ocspMutex.Unlock()
+
+	id := issuerID(ca.crt)
+	r := g.ocspResponders[id]
+	if r != nil && !r.generated {
+		// responses signed by an expired responder are rejected by clients, the configured one has to be replaced
+		if time.Now().After(r.crt.NotAfter) {
+			return nil, errors.New(fmt.Sprintf("OCSP responder certificate %s expired at %s", r.crt.Subject, r.crt.NotAfter.Format(time.RFC3339)))
+		}
+		return r, nil
+	}
+	if r != nil && time.Now().Before(r.crt.NotAfter.Add(-g.ocspResponderTTL()/2)) {
+		return r, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to generate OCSP responder key: %s", err))
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to generate serial number: %s", err))
+	}
+	cert := x509.Certificate{
+		SignatureAlgorithm: signatureAlgorithm(ca.key.Public()),
+		SerialNumber:       serialNumber,
+		Subject: pkix.Name{
+			Organization: ca.crt.Subject.Organization,
+			CommonName:   fmt.Sprintf("%s OCSP Responder", ca.crt.Subject.CommonName),
+		},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(g.ocspResponderTTL()),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		BasicConstraintsValid: true,
+		ExtraExtensions:       []pkix.Extension{{Id: oidOCSPNoCheck, Value: asn1.NullBytes}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &cert, ca.crt, key.Public(), ca.key)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to generate OCSP responder certificate: %s", err))
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	if g.ocspResponders == nil {
+		g.ocspResponders = make(map[string]*ocspResponder)
+	}
+	g.ocspResponders[id] = &ocspResponder{crt: crt, key: key, generated: true}
+	return g.ocspResponders[id], nil
+}
+
+func (g *CryptoTLS) ocspResponderTTL() time.Duration {
+	if g.OCSPResponderTTL <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return g.OCSPResponderTTL
+}
+
+// ParseOCSPRequest returns the serial number of the requested certificate and the id of its issuer.
+// ErrOCSPUnauthorized is returned when the certificate is not issued by a CA with a configured key.
+func (g *CryptoTLS) ParseOCSPRequest(request []byte) (*big.Int, string, error) {
+	req, err := ocsp.ParseRequest(request)
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("Failed to parse OCSP request: %s", err))
+	}
+	if !req.HashAlgorithm.Available() {
+		return nil, "", ErrOCSPUnauthorized
+	}
+
+	for _, ca := range g.keyedCAs() {
+		var spki struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}
+		if _, err = asn1.Unmarshal(ca.crt.RawSubjectPublicKeyInfo, &spki); err != nil {
+			return nil, "", err
+		}
+		h := req.HashAlgorithm.New()
+		h.Write(ca.crt.RawSubject)
+		nameHash := h.Sum(nil)
+		h.Reset()
+		h.Write(spki.PublicKey.RightAlign())
+		keyHash := h.Sum(nil)
+		if bytes.Equal(nameHash, req.IssuerNameHash) && bytes.Equal(keyHash, req.IssuerKeyHash) {
+			return req.SerialNumber, issuerID(ca.crt), nil
+		}
+	}
+	return nil, "", ErrOCSPUnauthorized
+}
+
+// CreateOCSPResponse returns the DER encoded response signed by the delegated responder of the issuer
+func (g *CryptoTLS) CreateOCSPResponse(response OCSPResponse) ([]byte, error) {
+	ca, err := g.findIssuer(response.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	r, err := g.responder(ca)
+	if err != nil {
+		return nil, err
+	}
+
+	template := ocsp.Response{
+		Status:       response.Status,
+		SerialNumber: response.Serial,
+		ThisUpdate:   response.ThisUpdate,
+		NextUpdate:   response.NextUpdate,
+		Certificate:  r.crt,
+	}
+	if response.Status == OCSP_REVOKED {
+		template.RevokedAt = response.RevokedAt
+		template.RevocationReason = response.Reason
+	}
+	der, err := ocsp.CreateResponse(ca.crt, r.crt, template, r.key)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to generate OCSP response: %s", err))
+	}
+	return der, nil
+}
@@ -0,0 +1,60 @@
+package generator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testResponder returns a delegated OCSP responder certificate issued by the root of the generator
+func testResponder(t *testing.T, g *CryptoTLS, notAfter time.Time) ([]byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test OCSP Responder"},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, g.rootCACrt, &key.PublicKey, g.rootCAKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key
+}
+
+func TestLoadOCSPResponderExpired(t *testing.T) {
+	g, _ := testRootGenerator(t, "Test Root CA")
+	crt, key := testResponder(t, g, time.Now().Add(-time.Hour))
+	if err := g.LoadOCSPResponder(crt, key); err == nil {
+		t.Fatal("expired OCSP responder certificate loaded")
+	}
+}
+
+func TestCreateOCSPResponseExpiredResponder(t *testing.T) {
+	g, _ := testRootGenerator(t, "Test Root CA")
+	crt, key := testResponder(t, g, time.Now().Add(time.Hour))
+	if err := g.LoadOCSPResponder(crt, key); err != nil {
+		t.Fatal(err)
+	}
+	response := OCSPResponse{Serial: big.NewInt(1), Status: OCSP_GOOD, ThisUpdate: time.Now(), NextUpdate: time.Now().Add(time.Hour)}
+	if _, err := g.CreateOCSPResponse(response); err != nil {
+		t.Fatal(err)
+	}
+
+	// the configured responder is kept when it expires, it is not replaced silently
+	g.ocspResponders[issuerID(g.rootCACrt)].crt.NotAfter = time.Now().Add(-time.Minute)
+	if _, err := g.CreateOCSPResponse(response); err == nil {
+		t.Fatal("OCSP response signed by an expired responder")
+	}
+}
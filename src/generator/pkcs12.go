@@ -0,0 +1,53 @@
+package generator
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/kuai6/nc-crtmgr/src/signer"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	FORMAT_PEM    = "pem"
+	FORMAT_PKCS12 = "pkcs12"
+)
+
+// IsSupportedFormat reports whether the certificate can be returned in the format
+func IsSupportedFormat(format string) bool {
+	return format == "" || format == FORMAT_PEM || format == FORMAT_PKCS12
+}
+
+// Bundle builds a password protected PKCS#12 bundle of the certificate, its private key and the CA chain.
+// An encrypted private key is expected to be protected with the same password.
+func (g *CryptoTLS) Bundle(certificate string, privateKey string, password string) ([]byte, error) {
+	if password == "" {
+		return nil, errors.New("Password is required for PKCS#12 bundle")
+	}
+
+	bcrt, _ := pem.Decode([]byte(certificate))
+	if bcrt == nil {
+		return nil, errors.New("Failed to parse certificate: no PEM data found")
+	}
+	crt, err := x509.ParseCertificate(bcrt.Bytes)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse certificate: %s", err.Error()))
+	}
+
+	key, err := signer.ParsePrivateKey([]byte(privateKey), []byte(password))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse certificate private key: %s", err.Error()))
+	}
+
+	encoder := pkcs12.Modern
+	if g.Pkcs12Legacy {
+		encoder = pkcs12.LegacyDES
+	}
+	bundle, err := encoder.Encode(key, crt, g.chainOf(crt), password)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to build PKCS#12 bundle: %s", err.Error()))
+	}
+	return bundle, nil
+}
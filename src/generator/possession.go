@@ -0,0 +1,41 @@
+package generator
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+)
+
+// VerifyPossession checks the signature over the message made with the private key of the certificate.
+// RSA signatures are PKCS#1 v1.5 or PSS and ECDSA signatures ASN.1 encoded, both over SHA-256,
+// Ed25519 signatures are made over the message itself.
+func (g *CryptoTLS) VerifyPossession(content string, message []byte, signature []byte) error {
+	crt, err := parseCertificate(content)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(message)
+	switch key := crt.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+		if rsa.VerifyPSS(key, crypto.SHA256, digest[:], signature, nil) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, digest[:], signature) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, message, signature) {
+			return nil
+		}
+	default:
+		return errors.New("Unsupported certificate public key")
+	}
+	return errors.New("Signature does not match the certificate key")
+}
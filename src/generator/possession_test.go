@@ -0,0 +1,73 @@
+package generator
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/kuai6/nc-crtmgr/src/signer"
+)
+
+func TestVerifyPossession(t *testing.T) {
+	g := testGenerator(t)
+	g.RsaBits = 2048
+	message := []byte("nc-crtmgr proof of possession:challenge")
+
+	tests := []struct {
+		name      string
+		algorithm string
+		sign      func(key crypto.Signer, message []byte) ([]byte, error)
+	}{
+		{"rsa pkcs1v15", KEY_ALGORITHM_RSA, func(key crypto.Signer, message []byte) ([]byte, error) {
+			digest := sha256.Sum256(message)
+			return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		}},
+		{"rsa pss", KEY_ALGORITHM_RSA, func(key crypto.Signer, message []byte) ([]byte, error) {
+			digest := sha256.Sum256(message)
+			return key.Sign(rand.Reader, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+		}},
+		{"ecdsa", KEY_ALGORITHM_ECDSA_P256, func(key crypto.Signer, message []byte) ([]byte, error) {
+			digest := sha256.Sum256(message)
+			return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		}},
+		{"ed25519", KEY_ALGORITHM_ED25519, func(key crypto.Signer, message []byte) ([]byte, error) {
+			return key.Sign(rand.Reader, message, crypto.Hash(0))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g.KeyAlgorithm = tt.algorithm
+			options := Options{}
+			options.SetUid("uid")
+			options.SetDid("did")
+			dto, err := g.Generate(options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := signer.ParsePrivateKey([]byte(dto.PrivateKey()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signature, err := tt.sign(key, message)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := g.VerifyPossession(dto.Certificate(), message, signature); err != nil {
+				t.Fatalf("valid signature rejected: %s", err)
+			}
+			if err := g.VerifyPossession(dto.Certificate(), []byte("challenge"), signature); err == nil {
+				t.Fatal("signature over another message accepted")
+			}
+			other, err := g.Generate(options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := g.VerifyPossession(other.Certificate(), message, signature); err == nil {
+				t.Fatal("signature of another key accepted")
+			}
+		})
+	}
+}
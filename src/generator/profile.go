@@ -0,0 +1,127 @@
+package generator
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	PROFILE_LEAF   = "leaf"
+	PROFILE_SERVER = "server"
+	PROFILE_CLIENT = "client"
+)
+
+// Profile describes the usage and limits of certificates issued with it
+type Profile struct {
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+	IsCA        bool
+	// MaxPathLen is the path length constraint of CA certificates, -1 means unlimited
+	MaxPathLen int
+	// MaxTTL is the longest allowed validity period, zero means unlimited
+	MaxTTL          time.Duration
+	AllowedSanTypes []string
+}
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digital_signature":  x509.KeyUsageDigitalSignature,
+	"content_commitment": x509.KeyUsageContentCommitment,
+	"key_encipherment":   x509.KeyUsageKeyEncipherment,
+	"data_encipherment":  x509.KeyUsageDataEncipherment,
+	"key_agreement":      x509.KeyUsageKeyAgreement,
+	"cert_sign":          x509.KeyUsageCertSign,
+	"crl_sign":           x509.KeyUsageCRLSign,
+	"encipher_only":      x509.KeyUsageEncipherOnly,
+	"decipher_only":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":              x509.ExtKeyUsageAny,
+	"server_auth":      x509.ExtKeyUsageServerAuth,
+	"client_auth":      x509.ExtKeyUsageClientAuth,
+	"code_signing":     x509.ExtKeyUsageCodeSigning,
+	"email_protection": x509.ExtKeyUsageEmailProtection,
+	"time_stamping":    x509.ExtKeyUsageTimeStamping,
+	"ocsp_signing":     x509.ExtKeyUsageOCSPSigning,
+}
+
+func ParseKeyUsage(names []string) (x509.KeyUsage, error) {
+	var usage x509.KeyUsage
+	for _, name := range names {
+		u, ok := keyUsages[name]
+		if !ok {
+			return 0, errors.New(fmt.Sprintf("Unknown key usage %q", name))
+		}
+		usage |= u
+	}
+	return usage, nil
+}
+
+func ParseExtKeyUsage(names []string) ([]x509.ExtKeyUsage, error) {
+	var usages []x509.ExtKeyUsage
+	for _, name := range names {
+		u, ok := extKeyUsages[name]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unknown extended key usage %q", name))
+		}
+		usages = append(usages, u)
+	}
+	return usages, nil
+}
+
+// DefaultProfiles returns the built in profiles, CA profiles are never built in and must be defined in config
+func DefaultProfiles() map[string]Profile {
+	allSanTypes := []string{SAN_DNS, SAN_IP, SAN_URI, SAN_EMAIL}
+	return map[string]Profile{
+		PROFILE_LEAF: {
+			KeyUsage:        x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			AllowedSanTypes: allSanTypes,
+		},
+		PROFILE_SERVER: {
+			KeyUsage:        x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			AllowedSanTypes: allSanTypes,
+		},
+		PROFILE_CLIENT: {
+			KeyUsage:        x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			AllowedSanTypes: []string{SAN_URI, SAN_EMAIL},
+		},
+	}
+}
+
+// check rejects certificates exceeding the profile limits
+func (p Profile) check(notBefore time.Time, notAfter time.Time, sans []SubjectAltName) error {
+	if p.MaxTTL > 0 && notAfter.Sub(notBefore) > p.MaxTTL {
+		return errors.New(fmt.Sprintf("Certificate validity period exceeds profile limit of %s", p.MaxTTL))
+	}
+	for _, san := range sans {
+		allowed := false
+		for _, t := range p.AllowedSanTypes {
+			if t == san.Type {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return errors.New(fmt.Sprintf("Subject alternative name type %s is not allowed by profile", san.Type))
+		}
+	}
+	return nil
+}
+
+func (p Profile) apply(cert *x509.Certificate) {
+	cert.KeyUsage = p.KeyUsage
+	cert.ExtKeyUsage = p.ExtKeyUsage
+	cert.BasicConstraintsValid = true
+	cert.IsCA = p.IsCA
+	if p.IsCA && p.MaxPathLen >= 0 {
+		cert.MaxPathLen = p.MaxPathLen
+		cert.MaxPathLenZero = p.MaxPathLen == 0
+	} else {
+		cert.MaxPathLen = -1
+	}
+}
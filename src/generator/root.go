@@ -0,0 +1,191 @@
+package generator
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// trustedRoot is a root accepted for validation besides the active one,
+// usually the previous root during rotation
+type trustedRoot struct {
+	crt *x509.Certificate
+	// retireAfter is the cutoff after which the root is no longer trusted, zero means never
+	retireAfter time.Time
+}
+
+// AddTrustedRoot adds a root accepted for validation until the retireAfter cutoff
+func (g *CryptoTLS) AddTrustedRoot(crt []byte, retireAfter time.Time) error {
+	crts, err := parseCertificates(crt)
+	if err != nil || len(crts) == 0 {
+		return errors.New(fmt.Sprintf("Failed to parse trusted root certificate: %v", err))
+	}
+	if !crts[0].IsCA {
+		return errors.New(fmt.Sprintf("Trusted root certificate %s is not a CA", crts[0].Subject))
+	}
+	g.trustedRoots = append(g.trustedRoots, trustedRoot{crt: crts[0], retireAfter: retireAfter})
+	return nil
+}
+
+// AddCrossCertificate adds a certificate of one root signed by another, it links chains
+// of certificates issued under the new root to the old one and vice versa
+func (g *CryptoTLS) AddCrossCertificate(crt []byte) error {
+	crts, err := parseCertificates(crt)
+	if err != nil || len(crts) == 0 {
+		return errors.New(fmt.Sprintf("Failed to parse cross certificate: %v", err))
+	}
+	cross := crts[0]
+	if !cross.IsCA {
+		return errors.New(fmt.Sprintf("Cross certificate %s is not a CA", cross.Subject))
+	}
+	for _, root := range g.allRoots() {
+		if cross.CheckSignatureFrom(root) == nil {
+			g.crossCerts = append(g.crossCerts, cross)
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("Cross certificate %s is not signed by a trusted root", cross.Subject))
+}
+
+// CrossSign certifies the public key and subject of another root with the active root key
+func (g *CryptoTLS) CrossSign(crt []byte) ([]byte, error) {
+	if g.rootCAKey == nil {
+		return nil, errors.New("Root certificate private key is not available")
+	}
+	crts, err := parseCertificates(crt)
+	if err != nil || len(crts) == 0 {
+		return nil, errors.New(fmt.Sprintf("Failed to parse root certificate: %v", err))
+	}
+	other := crts[0]
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to generate serial number: %s", err))
+	}
+	notAfter := other.NotAfter
+	if notAfter.After(g.rootCACrt.NotAfter) {
+		notAfter = g.rootCACrt.NotAfter
+	}
+	cert := x509.Certificate{
+		SignatureAlgorithm:    signatureAlgorithm(g.rootCAKey.Public()),
+		SerialNumber:          serialNumber,
+		Subject:               other.Subject,
+		SubjectKeyId:          other.SubjectKeyId,
+		NotBefore:             time.Now(),
+		NotAfter:              notAfter,
+		KeyUsage:              other.KeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            other.MaxPathLen,
+		MaxPathLenZero:        other.MaxPathLenZero,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &cert, g.rootCACrt, other.PublicKey, g.rootCAKey)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to generate cross certificate: %s", err))
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// allRoots returns the active root and the trusted ones regardless of the cutoff
+func (g *CryptoTLS) allRoots() []*x509.Certificate {
+	roots := []*x509.Certificate{g.rootCACrt}
+	for _, root := range g.trustedRoots {
+		roots = append(roots, root.crt)
+	}
+	return roots
+}
+
+// retired reports whether the certificate is a retired root at the given time, or a cross certificate
+// carrying the subject and key of one
+func (g *CryptoTLS) retired(crt *x509.Certificate, at time.Time) bool {
+	for _, root := range g.trustedRoots {
+		if root.retireAfter.IsZero() || at.Before(root.retireAfter) {
+			continue
+		}
+		if bytes.Equal(crt.RawSubject, root.crt.RawSubject) && publicKeysEqual(crt.PublicKey, root.crt.PublicKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedChain returns the first verified chain which does not pass a root retired at the given time,
+// certificates sent by clients may link to a retired root through a cross certificate
+func (g *CryptoTLS) trustedChain(chains [][]*x509.Certificate, at time.Time) ([]*x509.Certificate, error) {
+	for _, chain := range chains {
+		retired := false
+		for _, c := range chain {
+			retired = retired || g.retired(c, at)
+		}
+		if !retired {
+			return chain, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("Certificate chains to a root retired before %s", at.Format(time.RFC3339)))
+}
+
+// roots returns the pool of roots trusted at the given time
+func (g *CryptoTLS) roots(at time.Time) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(g.rootCACrt)
+	for _, root := range g.trustedRoots {
+		if root.retireAfter.IsZero() || at.Before(root.retireAfter) {
+			pool.AddCert(root.crt)
+		}
+	}
+	return pool
+}
+
+// rootOf returns the root which signed the certificate, the active root when it is not known
+func (g *CryptoTLS) rootOf(crt *x509.Certificate) *x509.Certificate {
+	for _, root := range g.allRoots() {
+		if crt.CheckSignatureFrom(root) == nil {
+			return root
+		}
+	}
+	return g.rootCACrt
+}
+
+// ClientCAs returns the pool of roots trusted now, used to request client certificates in the TLS handshake
+func (g *CryptoTLS) ClientCAs() *x509.CertPool {
+	return g.roots(time.Now())
+}
+
+// VerifyPeerCertificate verifies the client certificate presented in the TLS handshake against the roots
+// trusted now, the configured intermediates and the ones sent by the client. It has the signature of
+// tls.Config.VerifyPeerCertificate and accepts handshakes without a certificate.
+func (g *CryptoTLS) VerifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return nil
+	}
+	crts := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		crt, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Failed to parse client certificate: %s", err.Error()))
+		}
+		crts[i] = crt
+	}
+	now := time.Now()
+	opts := x509.VerifyOptions{
+		Roots:         g.roots(now),
+		Intermediates: g.intermediatePool(now),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, crt := range crts[1:] {
+		opts.Intermediates.AddCert(crt)
+	}
+	chains, err := crts[0].Verify(opts)
+	if err == nil {
+		_, err = g.trustedChain(chains, now)
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to verify client certificate: %s", err.Error()))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package generator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testRootGenerator returns a generator with a new self signed ECDSA root and the PEM of the root
+func testRootGenerator(t *testing.T, name string) (*CryptoTLS, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	g := &CryptoTLS{DefaultTTL: 30, KeyAlgorithm: KEY_ALGORITHM_ECDSA_P256, DefaultSubject: Subject{CommonName: "test"}}
+	if err := g.LoadRootCA(root, key); err != nil {
+		t.Fatal(err)
+	}
+	return g, root
+}
+
+func TestRetiredRootRotation(t *testing.T) {
+	old, oldRoot := testRootGenerator(t, "Old Root")
+	g, _ := testRootGenerator(t, "New Root")
+
+	options := Options{}
+	options.SetUid("uid")
+	options.SetDid("did")
+	leaf, err := old.Generate(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retireAfter := time.Now().Add(24 * time.Hour)
+	if err := g.AddTrustedRoot(oldRoot, retireAfter); err != nil {
+		t.Fatal(err)
+	}
+	// the old root key certified by the new root links old certificates to the new root
+	cross, err := g.CrossSign(oldRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddCrossCertificate(cross); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := g.VerifyChain(leaf.Certificate(), "", time.Now()); err != nil {
+		t.Fatalf("certificate of the old root rejected before the cutoff: %s", err)
+	}
+	after := retireAfter.Add(time.Hour)
+	if chain, err := g.VerifyChain(leaf.Certificate(), "", after); err == nil {
+		t.Fatalf("certificate of the old root accepted after the cutoff with chain %v", chain)
+	}
+	if chain, err := g.VerifyChain(leaf.Certificate(), string(cross), after); err == nil {
+		t.Fatalf("certificate of the old root accepted after the cutoff through the given cross certificate with chain %v", chain)
+	}
+
+	issued, err := g.Generate(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.VerifyChain(issued.Certificate(), "", after); err != nil {
+		t.Fatalf("certificate of the new root rejected after the cutoff: %s", err)
+	}
+}
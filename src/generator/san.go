@@ -0,0 +1,146 @@
+package generator
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	SAN_DNS   = "dns"
+	SAN_IP    = "ip"
+	SAN_URI   = "uri"
+	SAN_EMAIL = "email"
+)
+
+type SubjectAltName struct {
+	Type  string
+	Value string
+}
+
+// SanPolicy is the allowlist of subject alternative names which may be embedded into certificates.
+// DNS, URI and email patterns may contain '*' wildcards, for DNS names the wildcard does not cross a label.
+// IP patterns are addresses or CIDR ranges. An empty list denies the type.
+type SanPolicy struct {
+	DNS   []string
+	IP    []string
+	URI   []string
+	Email []string
+}
+
+func (p SanPolicy) Check(san SubjectAltName) error {
+	var allowed bool
+	switch san.Type {
+	case SAN_DNS:
+		value := strings.ToLower(san.Value)
+		if value == "" || strings.ContainsAny(value, " /:@") {
+			return errors.New(fmt.Sprintf("Invalid DNS name %q", san.Value))
+		}
+		for _, pattern := range p.DNS {
+			if matchPattern(strings.ToLower(pattern), value, "[^.]+") {
+				allowed = true
+				break
+			}
+		}
+	case SAN_IP:
+		ip := net.ParseIP(san.Value)
+		if ip == nil {
+			return errors.New(fmt.Sprintf("Invalid IP address %q", san.Value))
+		}
+		for _, pattern := range p.IP {
+			if _, network, err := net.ParseCIDR(pattern); err == nil {
+				if network.Contains(ip) {
+					allowed = true
+					break
+				}
+			} else if ip.Equal(net.ParseIP(pattern)) {
+				allowed = true
+				break
+			}
+		}
+	case SAN_URI:
+		if u, err := url.Parse(san.Value); err != nil || u.Scheme == "" {
+			return errors.New(fmt.Sprintf("Invalid URI %q", san.Value))
+		}
+		for _, pattern := range p.URI {
+			if matchPattern(pattern, san.Value, ".*") {
+				allowed = true
+				break
+			}
+		}
+	case SAN_EMAIL:
+		if a, err := mail.ParseAddress(san.Value); err != nil || a.Address != san.Value {
+			return errors.New(fmt.Sprintf("Invalid email address %q", san.Value))
+		}
+		for _, pattern := range p.Email {
+			if matchPattern(strings.ToLower(pattern), strings.ToLower(san.Value), ".*") {
+				allowed = true
+				break
+			}
+		}
+	default:
+		return errors.New(fmt.Sprintf("Unsupported subject alternative name type %q", san.Type))
+	}
+
+	if !allowed {
+		return errors.New(fmt.Sprintf("Subject alternative name %s:%s is not allowed", san.Type, san.Value))
+	}
+	return nil
+}
+
+// matchPattern matches the value against the pattern where '*' is replaced with the wildcard expression
+func matchPattern(pattern string, value string, wildcard string) bool {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	r, err := regexp.Compile("^" + strings.Join(parts, wildcard) + "$")
+	if err != nil {
+		return false
+	}
+	return r.MatchString(value)
+}
+
+func applySubjectAltNames(cert *x509.Certificate, sans []SubjectAltName) error {
+	for _, san := range sans {
+		switch san.Type {
+		case SAN_DNS:
+			cert.DNSNames = append(cert.DNSNames, strings.ToLower(san.Value))
+		case SAN_IP:
+			cert.IPAddresses = append(cert.IPAddresses, net.ParseIP(san.Value))
+		case SAN_URI:
+			u, err := url.Parse(san.Value)
+			if err != nil {
+				return err
+			}
+			cert.URIs = append(cert.URIs, u)
+		case SAN_EMAIL:
+			cert.EmailAddresses = append(cert.EmailAddresses, san.Value)
+		default:
+			return errors.New(fmt.Sprintf("Unsupported subject alternative name type %q", san.Type))
+		}
+	}
+	return nil
+}
+
+func subjectAltNames(dnsNames []string, ips []net.IP, uris []*url.URL, emails []string) []SubjectAltName {
+	var sans []SubjectAltName
+	for _, name := range dnsNames {
+		sans = append(sans, SubjectAltName{Type: SAN_DNS, Value: name})
+	}
+	for _, ip := range ips {
+		sans = append(sans, SubjectAltName{Type: SAN_IP, Value: ip.String()})
+	}
+	for _, u := range uris {
+		sans = append(sans, SubjectAltName{Type: SAN_URI, Value: u.String()})
+	}
+	for _, email := range emails {
+		sans = append(sans, SubjectAltName{Type: SAN_EMAIL, Value: email})
+	}
+	return sans
+}
@@ -0,0 +1,109 @@
+// Package memory provides a certificate.Repository kept in process memory, for tests and embedded use.
+// Certificates are lost on restart.
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/kuai6/nc-crtmgr/src/certificate"
+)
+
+type CertificateRepository struct {
+	mutex sync.RWMutex
+	// serials keeps the insertion order, certificates are returned in it like from the other backends
+	serials      []string
+	certificates map[string]certificate.Certificate
+}
+
+func NewCertificateRepository() *CertificateRepository {
+	return &CertificateRepository{
+		certificates: map[string]certificate.Certificate{},
+	}
+}
+
+// Store inserts the certificate or replaces the stored one with the same serial
+func (r *CertificateRepository) Store(certificate *certificate.Certificate) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.certificates[certificate.GetSerial()]; !ok {
+		r.serials = append(r.serials, certificate.GetSerial())
+	}
+	r.certificates[certificate.GetSerial()] = clone(certificate)
+	return nil
+}
+
+func (r *CertificateRepository) Find(serial big.Int) (*certificate.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	crt, ok := r.certificates[serial.String()]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("certificate with serial %s not found", serial.String()))
+	}
+	result := clone(&crt)
+	return &result, nil
+}
+
+func (r *CertificateRepository) FindAll() []*certificate.Certificate {
+	return r.filter(func(crt *certificate.Certificate) bool {
+		return true
+	})
+}
+
+func (r *CertificateRepository) FindExpired() []*certificate.Certificate {
+	now := time.Now()
+	return r.filter(func(crt *certificate.Certificate) bool {
+		return !crt.GetValidTill().After(now)
+	})
+}
+
+func (r *CertificateRepository) FindByStatus(status int) []*certificate.Certificate {
+	return r.filter(func(crt *certificate.Certificate) bool {
+		return crt.GetStatus() == status
+	})
+}
+
+func (r *CertificateRepository) FindByUid(uid string) []*certificate.Certificate {
+	return r.filter(func(crt *certificate.Certificate) bool {
+		return crt.GetUid() == uid
+	})
+}
+
+func (r *CertificateRepository) FindByDid(did string) []*certificate.Certificate {
+	return r.filter(func(crt *certificate.Certificate) bool {
+		return crt.GetDid() == did
+	})
+}
+
+func (r *CertificateRepository) FindByGidAndDidAndStatus(uid string, did string, status int) []*certificate.Certificate {
+	return r.filter(func(crt *certificate.Certificate) bool {
+		return crt.GetUid() == uid && crt.GetDid() == did && crt.GetStatus() == status
+	})
+}
+
+// filter returns copies of the matching certificates, callers may change them without storing
+func (r *CertificateRepository) filter(match func(crt *certificate.Certificate) bool) []*certificate.Certificate {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	result := []*certificate.Certificate{}
+	for _, serial := range r.serials {
+		crt := r.certificates[serial]
+		if match(&crt) {
+			c := clone(&crt)
+			result = append(result, &c)
+		}
+	}
+	return result
+}
+
+func clone(crt *certificate.Certificate) certificate.Certificate {
+	c := *crt
+	c.History = append([]certificate.StatusChange(nil), crt.History...)
+	return c
+}
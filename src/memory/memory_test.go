@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/kuai6/nc-crtmgr/src/certificate"
+	"github.com/kuai6/nc-crtmgr/src/certificate/repositorytest"
+)
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) certificate.Repository {
+		return NewCertificateRepository()
+	})
+}
@@ -1,69 +1,93 @@
 package mongo
 
 import (
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
-	"github.com/kuai6/nc-crtmgr/src/certificate"
-	"math/big"
+	"context"
 	"errors"
 	"fmt"
+	"math/big"
 	"time"
+
+	"github.com/kuai6/nc-crtmgr/src/certificate"
+	"go.mongodb.org/mongo-driver/bson"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Connect creates the pooled client of the deployment, the timeout limits connecting and server selection
+func Connect(uri string, maxPoolSize uint64, timeout time.Duration) (*driver.Client, error) {
+	opts := options.Client().
+		ApplyURI(uri).
+		SetMaxPoolSize(maxPoolSize).
+		SetConnectTimeout(timeout).
+		SetServerSelectionTimeout(timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client, err := driver.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	return client, nil
+}
+
+// CertificateRepository keeps certificates as documents with lowercase field names,
+// the same shape the mgo based versions stored
 type CertificateRepository struct {
-	collectionName string
-	db             string
-	session        *mgo.Session
+	collection *driver.Collection
+	timeout    time.Duration
 }
 
-func NewCertificateRepository(db string, session *mgo.Session) (certificate.Repository, error) {
+func NewCertificateRepository(db *driver.Database, timeout time.Duration) (certificate.Repository, error) {
 	r := &CertificateRepository{
-		collectionName: "certificate",
-		db:             db,
-		session:        session,
+		collection: db.Collection("certificate"),
+		timeout:    timeout,
 	}
 
-	index := mgo.Index{
-		Key:        []string{"serial"},
-		Unique:     true,
-		DropDups:   true,
-		Background: true,
-		Sparse:     true,
+	// same options as the index created by the mgo based versions, so existing indexes are reused
+	index := driver.IndexModel{
+		Keys:    bson.D{{Key: "serial", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
 	}
 
-	sess := r.session.Copy()
-	defer sess.Close()
-
-	c := sess.DB(r.db).C(r.collectionName)
+	ctx, cancel := r.context()
+	defer cancel()
 
-	if err := c.EnsureIndex(index); err != nil {
+	if _, err := r.collection.Indexes().CreateOne(ctx, index); err != nil {
 		return nil, err
 	}
 
 	return r, nil
 }
 
-func (r *CertificateRepository) Store(certificate *certificate.Certificate) error {
-	sess := r.session.Copy()
-	defer sess.Close()
+func (r *CertificateRepository) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), r.timeout)
+}
 
-	c := sess.DB(r.db).C(r.collectionName)
+func (r *CertificateRepository) Store(certificate *certificate.Certificate) error {
+	ctx, cancel := r.context()
+	defer cancel()
 
-	_, err := c.Upsert(bson.M{"serial": certificate.GetSerial()}, bson.M{"$set": certificate})
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"serial": certificate.GetSerial()},
+		bson.M{"$set": certificate},
+		options.Update().SetUpsert(true))
 
 	return err
 }
 
 func (r *CertificateRepository) Find(serial big.Int) (*certificate.Certificate, error) {
-	sess := r.session.Copy()
-	defer sess.Close()
-
-	c := sess.DB(r.db).C(r.collectionName)
+	ctx, cancel := r.context()
+	defer cancel()
 
 	var result certificate.Certificate
-	if err := c.Find(bson.M{"serial": serial}).One(&result); err != nil {
-		if err == mgo.ErrNotFound {
-			return nil, errors.New(fmt.Sprintf("certificate with serial %d not found", serial))
+	// serials are stored as decimal strings
+	if err := r.collection.FindOne(ctx, bson.M{"serial": serial.String()}).Decode(&result); err != nil {
+		if err == driver.ErrNoDocuments {
+			return nil, errors.New(fmt.Sprintf("certificate with serial %s not found", serial.String()))
 		}
 		return nil, err
 	}
@@ -72,45 +96,45 @@ func (r *CertificateRepository) Find(serial big.Int) (*certificate.Certificate,
 }
 
 func (r *CertificateRepository) FindAll() []*certificate.Certificate {
-	sess := r.session.Copy()
-	defer sess.Close()
-
-	c := sess.DB(r.db).C(r.collectionName)
-
-	var result []*certificate.Certificate
-	if err := c.Find(bson.M{}).All(&result); err != nil {
-		return []*certificate.Certificate{}
-	}
-
-	return result
+	return r.find(bson.M{})
 }
 
 func (r *CertificateRepository) FindExpired() []*certificate.Certificate {
-	sess := r.session.Copy()
-	defer sess.Close()
+	return r.find(bson.M{"validtill": bson.M{"$lte": time.Now()}})
+}
 
-	c := sess.DB(r.db).C(r.collectionName)
+func (r *CertificateRepository) FindByStatus(status int) []*certificate.Certificate {
+	return r.find(bson.M{"status": status})
+}
 
-	var result []*certificate.Certificate
-	if err := c.Find(bson.M{"validtill": bson.M{"$lte": time.Now()}}).All(&result); err != nil {
-		return []*certificate.Certificate{}
-	}
+func (r *CertificateRepository) FindByUid(uid string) []*certificate.Certificate {
+	return r.find(bson.M{"uid": uid})
+}
 
-	return result
+func (r *CertificateRepository) FindByDid(did string) []*certificate.Certificate {
+	return r.find(bson.M{"did": did})
 }
 
 func (r *CertificateRepository) FindByGidAndDidAndStatus(uid string, did string, status int) []*certificate.Certificate {
-	sess := r.session.Copy()
-	defer sess.Close()
-
-	c := sess.DB(r.db).C(r.collectionName)
-
-	var result []*certificate.Certificate
-	if err := c.Find(bson.M{"$and": []bson.M{
+	return r.find(bson.M{"$and": []bson.M{
 		{"uid": uid},
 		{"did": did},
 		{"status": status},
-	}}).All(&result); err != nil {
+	}})
+}
+
+// find returns the matching certificates or an empty list on failure
+func (r *CertificateRepository) find(filter bson.M) []*certificate.Certificate {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return []*certificate.Certificate{}
+	}
+
+	result := []*certificate.Certificate{}
+	if err := cursor.All(ctx, &result); err != nil {
 		return []*certificate.Certificate{}
 	}
 
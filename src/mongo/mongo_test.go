@@ -0,0 +1,37 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kuai6/nc-crtmgr/src/certificate"
+	"github.com/kuai6/nc-crtmgr/src/certificate/repositorytest"
+)
+
+// TestRepository runs against the deployment of MONGO_URI, each test uses a database dropped afterwards
+func TestRepository(t *testing.T) {
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI is not set")
+	}
+	client, err := Connect(uri, 10, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	n := 0
+	repositorytest.Run(t, func(t *testing.T) certificate.Repository {
+		n++
+		db := client.Database(fmt.Sprintf("crtmgr_test_%d_%d", time.Now().UnixNano(), n))
+		t.Cleanup(func() { db.Drop(context.Background()) })
+		repository, err := NewCertificateRepository(db, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repository
+	})
+}
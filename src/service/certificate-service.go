@@ -5,6 +5,9 @@ import (
 	"github.com/kuai6/nc-crtmgr/src/certificate"
 	"time"
 	"errors"
+	"fmt"
+	"math/big"
+	"strings"
 )
 
 type CertificateServiceInterface interface {
@@ -30,47 +33,178 @@ func (c *CertificateService) Save(certificate *certificate.Certificate) error {
 }
 
 func (c *CertificateService) GenerateCertificate(options generator.Options) (*certificate.Certificate, error) {
-	var err error
 	certificateDTO, err := c.generator.Generate(options)
-	if err == nil {
-		certificates := c.certificates.FindByGidAndDidAndStatus(options.Uid(), options.Did(), certificate.STATUS_ACTIVE)
-		for _, crt_ := range certificates {
-			crt_.SetNotActive()
-			c.certificates.Store(crt_)
-		}
+	if err != nil {
+		return nil, err
+	}
+	return c.storeIssued(certificateDTO, options)
+}
+
+// SignCertificate issues a certificate for the given CSR, the private key stays with the caller
+func (c *CertificateService) SignCertificate(request string, options generator.Options) (*certificate.Certificate, error) {
+	certificateDTO, err := c.generator.Sign(request, options)
+	if err != nil {
+		return nil, err
+	}
+	return c.storeIssued(certificateDTO, options)
+}
+
+// storeIssued deactivates previous certificates of the uid and did and stores the issued one
+func (c *CertificateService) storeIssued(certificateDTO *generator.CertificateDTO, options generator.Options) (*certificate.Certificate, error) {
+	certificates := c.certificates.FindByGidAndDidAndStatus(options.Uid(), options.Did(), certificate.STATUS_ACTIVE)
+	for _, crt_ := range certificates {
+		crt_.SetNotActive()
+		c.certificates.Store(crt_)
 	}
+
 	crt := new(certificate.Certificate)
+	crt.SetActive()
 	crt.SetCreationDateTime(time.Now())
-	crt.SetPrivateKey(certificateDTO.PrivateKey())
+	if certificateDTO.PrivateKey() != "" {
+		crt.SetPrivateKey(certificateDTO.PrivateKey())
+	}
 	crt.SetCertificate(certificateDTO.Certificate())
 	crt.SetSerial(certificateDTO.Serial())
 	crt.SetValidTill(certificateDTO.NotAfter())
-	crt.SetActive()
 	crt.SetUid(options.Uid())
 	crt.SetDid(options.Did())
 	if time.Now().After(certificateDTO.NotAfter()) {
 		crt.SetNotActive()
 	}
-	err = c.certificates.Store(crt)
+	err := c.certificates.Store(crt)
 	return crt, err
 }
 
+// GenerateBundledCertificate generates the certificate with its PKCS#12 bundle. The certificate is stored
+// and supersedes the active one only when the bundle was built.
+func (c *CertificateService) GenerateBundledCertificate(options generator.Options, password string) (*certificate.Certificate, []byte, error) {
+	certificateDTO, err := c.generator.Generate(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle, err := c.generator.Bundle(certificateDTO.Certificate(), certificateDTO.PrivateKey(), password)
+	if err != nil {
+		return nil, nil, err
+	}
+	crt, err := c.storeIssued(certificateDTO, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return crt, bundle, nil
+}
+
+// ValidateCertificate checks the candidate like ValidationReport and returns the first failure as *ValidationError
 func (c *CertificateService) ValidateCertificate(uid string, did string, candidate string) (bool, error) {
-	// first of all try to get uid and did
-	cUid, cDid, _ := c.generator.ParseUidDid(candidate)
-	if cUid != "" && cDid != "" {
-		if cUid != uid || cDid != did {
-			return false, errors.New("Certificate UID or DID not match with given")
+	report := c.ValidationReport(uid, did, candidate)
+	if err := report.Err(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ValidationReport checks the stored status of the candidate and its chain now
+func (c *CertificateService) ValidationReport(uid string, did string, candidate string) *ValidationReport {
+	return c.ValidationReportAt(uid, did, candidate, time.Now())
+}
+
+// ValidationReportAt checks the candidate as of the given moment using the stored status history.
+// Candidates unknown to the repository are accepted only when issued by the certificate of the uid and did
+// which was active at the moment.
+func (c *CertificateService) ValidationReportAt(uid string, did string, candidate string, at time.Time) *ValidationReport {
+	report := &ValidationReport{Status: "unknown", At: at}
+	info, err := c.generator.Inspect(candidate)
+	if err != nil {
+		report.fail(CHECK_PARSE, VALIDATION_MALFORMED, err.Error())
+		return report
+	}
+	report.Certificate = info
+	report.pass(CHECK_PARSE)
+
+	record, err := c.certificates.Find(*info.Serial)
+	if err != nil {
+		record = nil
+	}
+	checkIdentity(report, info, record, uid, did)
+
+	if record != nil {
+		report.Status = certificate.StatusName(record.GetStatus())
+		checkRecord(report, record, at)
+		report.Chain, err = c.generator.VerifyChain(candidate, "", at)
+		if err != nil {
+			report.fail(CHECK_SIGNATURE, VALIDATION_CHAIN, err.Error())
+		} else {
+			report.pass(CHECK_SIGNATURE)
 		}
+		return report
 	}
 
 	//try to fetch intermediate certificate with give uid and did
-	itrCrt := c.FetchActiveCertificateByUidAndDid(uid, did)
+	itrCrt := c.fetchActiveAt(uid, did, at)
 	if itrCrt == nil {
-		return false, errors.New("Certificate wit given UID and DID not found")
+		report.fail(CHECK_STATUS, VALIDATION_NOT_FOUND, "Certificate wit given UID and DID not found")
+		return report
+	}
+	// issued by the CA itself but never stored
+	if _, err := c.generator.VerifyChain(candidate, "", at); err == nil {
+		report.fail(CHECK_STATUS, VALIDATION_UNKNOWN_SERIAL, fmt.Sprintf("Certificate with serial %s is unknown", info.Serial.String()))
+		return report
+	}
+	report.pass(CHECK_STATUS)
+	if at.After(info.NotAfter) {
+		report.fail(CHECK_EXPIRY, VALIDATION_EXPIRED, fmt.Sprintf("Certificate expired at %s", info.NotAfter.Format(time.RFC3339)))
+	} else {
+		report.pass(CHECK_EXPIRY)
 	}
+	report.Chain, err = c.generator.VerifyChain(candidate, itrCrt.GetCertificate(), at)
+	if err != nil {
+		report.fail(CHECK_SIGNATURE, VALIDATION_CHAIN, err.Error())
+	} else {
+		report.pass(CHECK_SIGNATURE)
+	}
+	return report
+}
 
-	return c.generator.Validate(candidate, itrCrt.GetCertificate())
+// PossessionReport checks the candidate like ValidationReport and that the signature over the possession message
+// of the challenge was made with the key of the candidate
+func (c *CertificateService) PossessionReport(uid string, did string, candidate string, challenge string, signature []byte) *ValidationReport {
+	report := c.ValidationReport(uid, did, candidate)
+	if report.Certificate == nil {
+		return report
+	}
+	if err := c.generator.VerifyPossession(candidate, PossessionMessage(challenge), signature); err != nil {
+		report.fail(CHECK_POSSESSION, VALIDATION_POSSESSION, err.Error())
+	} else {
+		report.pass(CHECK_POSSESSION)
+	}
+	return report
+}
+
+// fetchActiveAt returns the certificate of the uid and did which was active at the moment
+func (c *CertificateService) fetchActiveAt(uid string, did string, at time.Time) *certificate.Certificate {
+	for _, crt := range c.certificates.FindByUid(uid) {
+		if crt.GetDid() != did {
+			continue
+		}
+		if status, issued := crt.StatusAt(at); issued && status == certificate.STATUS_ACTIVE {
+			return crt
+		}
+	}
+	return nil
+}
+
+// findByContent returns the certificate of the uid and did in the status with the candidate content
+func (c *CertificateService) findByContent(uid string, did string, candidate string, status int) *certificate.Certificate {
+	for _, crt := range c.certificates.FindByGidAndDidAndStatus(uid, did, status) {
+		if strings.TrimSpace(crt.GetCertificate()) == strings.TrimSpace(candidate) {
+			return crt
+		}
+	}
+	return nil
+}
+
+// FetchSuspendedCertificate returns the suspended certificate of the uid and did with the candidate content
+func (c *CertificateService) FetchSuspendedCertificate(uid string, did string, candidate string) *certificate.Certificate {
+	return c.findByContent(uid, did, candidate, certificate.STATUS_SUSPENDED)
 }
 
 func (c *CertificateService) FetchCertificateObjectByItContent(candidate string) (*certificate.Certificate, error) {
@@ -82,7 +216,7 @@ func (c *CertificateService) FetchCertificateObjectByItContent(candidate string)
 	return c.FetchActiveCertificateByUidAndDid(uid, did), nil
 }
 
-func (c *CertificateService) FetchActiveCertificateByUidAndDid(uid string, did string) (*certificate.Certificate) {
+func (c *CertificateService) FetchActiveCertificateByUidAndDid(uid string, did string) *certificate.Certificate {
 	certificates := c.certificates.FindByGidAndDidAndStatus(uid, did, certificate.STATUS_ACTIVE)
 	if len(certificates) > 0 {
 		return certificates[0]
@@ -90,17 +224,100 @@ func (c *CertificateService) FetchActiveCertificateByUidAndDid(uid string, did s
 	return nil
 }
 
-func (c *CertificateService) Withdraw(certificate *certificate.Certificate) error {
+// Withdraw revokes the certificate with the RFC 5280 reason code, the actor is who requested it
+func (c *CertificateService) Withdraw(certificate *certificate.Certificate, reason int, comment string, invalidityDate time.Time, actor string) error {
 	certificate.SetWithdrawn()
 	certificate.SetWithdrawalDateTime(time.Now())
-	c.Save(certificate)
-	return nil
+	certificate.SetRevocationReason(reason)
+	certificate.SetRevocationComment(comment)
+	certificate.SetInvalidityDate(invalidityDate)
+	certificate.SetRevokedBy(actor)
+	return c.Save(certificate)
 }
 
+// Suspend puts the certificate on hold, it is reported as revoked with certificateHold reason until reinstated
+func (c *CertificateService) Suspend(crt *certificate.Certificate, comment string, actor string) error {
+	if crt.GetStatus() != certificate.STATUS_ACTIVE {
+		return errors.New("Only active certificate can be suspended")
+	}
+	crt.SetSuspended()
+	crt.SetWithdrawalDateTime(time.Now())
+	crt.SetRevocationReason(certificate.REASON_CERTIFICATE_HOLD)
+	crt.SetRevocationComment(comment)
+	crt.SetRevokedBy(actor)
+	return c.Save(crt)
+}
 
+// Reinstate releases the hold of the suspended certificate
+func (c *CertificateService) Reinstate(crt *certificate.Certificate) error {
+	if crt.GetStatus() != certificate.STATUS_SUSPENDED {
+		return errors.New("Certificate is not suspended")
+	}
+	if c.FetchActiveCertificateByUidAndDid(crt.GetUid(), crt.GetDid()) != nil {
+		return errors.New("Another certificate with given UID and DID is active")
+	}
+	if time.Now().After(crt.GetValidTill()) {
+		return errors.New("Certificate is expired")
+	}
+	crt.SetActive()
+	crt.SetWithdrawalDateTime(time.Time{})
+	crt.SetRevocationReason(certificate.REASON_UNSPECIFIED)
+	crt.SetRevocationComment("")
+	crt.SetRevokedBy("")
+	return c.Save(crt)
+}
+
+// RevokeBySerial withdraws the certificate with the decimal serial number regardless of its status and expiration
+func (c *CertificateService) RevokeBySerial(serial string, reason int, comment string, invalidityDate time.Time, actor string) ([]string, error) {
+	crt, err := c.FetchCertificateBySerial(serial)
+	if err != nil {
+		return nil, err
+	}
+	return c.revokeAll([]*certificate.Certificate{crt}, reason, comment, invalidityDate, actor)
+}
+
+// RevokeByUid withdraws all certificates of the user
+func (c *CertificateService) RevokeByUid(uid string, reason int, comment string, invalidityDate time.Time, actor string) ([]string, error) {
+	return c.revokeAll(c.certificates.FindByUid(uid), reason, comment, invalidityDate, actor)
+}
+
+// RevokeByDid withdraws all certificates of the device
+func (c *CertificateService) RevokeByDid(did string, reason int, comment string, invalidityDate time.Time, actor string) ([]string, error) {
+	return c.revokeAll(c.certificates.FindByDid(did), reason, comment, invalidityDate, actor)
+}
+
+// revokeAll withdraws the certificates which are not withdrawn yet and returns their serials
+func (c *CertificateService) revokeAll(certificates []*certificate.Certificate, reason int, comment string, invalidityDate time.Time, actor string) ([]string, error) {
+	serials := []string{}
+	for _, crt := range certificates {
+		if crt.GetStatus() == certificate.STATUS_WITHDRAWN {
+			continue
+		}
+		if err := c.Withdraw(crt, reason, comment, invalidityDate, actor); err != nil {
+			return serials, err
+		}
+		serials = append(serials, crt.GetSerial())
+	}
+	return serials, nil
+}
+
+// FetchCertificateBySerial returns the certificate with the decimal serial number
+func (c *CertificateService) FetchCertificateBySerial(serial string) (*certificate.Certificate, error) {
+	number, ok := new(big.Int).SetString(serial, 10)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Invalid serial number %s", serial))
+	}
+	return c.certificates.Find(*number)
+}
+
+// RemoveExpired deactivates expired active certificates. Withdrawn and suspended ones keep their status,
+// OCSP and CRL report it after the expiration as well.
 func (c *CertificateService) RemoveExpired() {
 	certificates := c.certificates.FindExpired()
 	for _, crt := range certificates {
+		if crt.GetStatus() != certificate.STATUS_ACTIVE {
+			continue
+		}
 		crt.SetNotActive()
 		c.certificates.Store(crt)
 	}
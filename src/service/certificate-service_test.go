@@ -0,0 +1,195 @@
+package service
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kuai6/nc-crtmgr/src/certificate"
+	"github.com/kuai6/nc-crtmgr/src/generator"
+	"github.com/kuai6/nc-crtmgr/src/memory"
+	"github.com/kuai6/nc-crtmgr/src/signer"
+)
+
+// testGenerator returns a generator signing with a new root key
+func testGenerator(t *testing.T) *generator.CryptoTLS {
+	root, key := testCA(t, "Test Root CA", nil, nil)
+	g := &generator.CryptoTLS{DefaultTTL: 30, KeyAlgorithm: generator.KEY_ALGORITHM_ECDSA_P256}
+	if err := g.LoadRootCA(encodePEM(root), key); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// reportCode returns the code of the first failed check, empty when the report passed
+func reportCode(report *ValidationReport) string {
+	if err := report.Err(); err != nil {
+		return err.(*ValidationError).Code
+	}
+	return ""
+}
+
+func TestValidationReportAt(t *testing.T) {
+	s := NewCertificateService(memory.NewCertificateRepository(), testGenerator(t))
+
+	beforeIssue := time.Now().Add(-time.Minute)
+	crt := issue(t, s, "uid", "did")
+	issued := time.Now()
+	if err := s.Withdraw(crt, 0, "lost", time.Time{}, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		code string
+	}{
+		{"before issuance", beforeIssue, VALIDATION_NOT_ISSUED},
+		{"while active", issued, ""},
+		{"after withdrawal", time.Now(), VALIDATION_REVOKED},
+		{"after expiry", crt.GetValidTill().Add(time.Hour), VALIDATION_REVOKED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := s.ValidationReportAt("uid", "did", crt.GetCertificate(), tt.at)
+			if code := reportCode(report); code != tt.code {
+				t.Fatalf("expected code %q, got %q in %+v", tt.code, code, report.Checks)
+			}
+		})
+	}
+}
+
+func TestValidationReportAtSuperseded(t *testing.T) {
+	s := NewCertificateService(memory.NewCertificateRepository(), testGenerator(t))
+
+	first := issue(t, s, "uid", "did")
+	between := time.Now()
+	second := issue(t, s, "uid", "did")
+
+	if code := reportCode(s.ValidationReportAt("uid", "did", first.GetCertificate(), between)); code != "" {
+		t.Fatalf("superseded certificate failed before it was superseded with %q", code)
+	}
+	if code := reportCode(s.ValidationReportAt("uid", "did", first.GetCertificate(), time.Now())); code != VALIDATION_NOT_ACTIVE {
+		t.Fatalf("superseded certificate validated with code %q", code)
+	}
+	if code := reportCode(s.ValidationReportAt("uid", "did", second.GetCertificate(), time.Now())); code != "" {
+		t.Fatalf("active certificate failed with %q", code)
+	}
+	if code := reportCode(s.ValidationReportAt("other", "did", second.GetCertificate(), time.Now())); code != VALIDATION_IDENTITY_MISMATCH {
+		t.Fatalf("certificate of another uid validated with code %q", code)
+	}
+}
+
+func TestSuspendReinstateWithdraw(t *testing.T) {
+	repository := memory.NewCertificateRepository()
+	s := NewCertificateService(repository, testGenerator(t))
+	crt := issue(t, s, "uid", "did")
+
+	if err := s.Reinstate(crt); err == nil {
+		t.Fatal("active certificate reinstated")
+	}
+	if err := s.Suspend(crt, "lost", "support"); err != nil {
+		t.Fatal(err)
+	}
+	if crt.GetStatus() != certificate.STATUS_SUSPENDED || crt.GetRevocationReason() != certificate.REASON_CERTIFICATE_HOLD {
+		t.Fatalf("suspended certificate has status %s and reason %d", certificate.StatusName(crt.GetStatus()), crt.GetRevocationReason())
+	}
+	if err := s.Suspend(crt, "lost", "support"); err == nil {
+		t.Fatal("suspended certificate suspended again")
+	}
+	if code := reportCode(s.ValidationReport("uid", "did", crt.GetCertificate())); code != VALIDATION_ON_HOLD {
+		t.Fatalf("suspended certificate validated with code %q", code)
+	}
+	if found := s.FetchSuspendedCertificate("uid", "did", crt.GetCertificate()); found == nil {
+		t.Fatal("suspended certificate not found")
+	}
+
+	if err := s.Reinstate(crt); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.FetchCertificateBySerial(crt.GetSerial())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.GetStatus() != certificate.STATUS_ACTIVE || stored.GetRevocationReason() != certificate.REASON_UNSPECIFIED {
+		t.Fatalf("reinstated certificate has status %s and reason %d", certificate.StatusName(stored.GetStatus()), stored.GetRevocationReason())
+	}
+	if code := reportCode(s.ValidationReport("uid", "did", crt.GetCertificate())); code != "" {
+		t.Fatalf("reinstated certificate failed with %q", code)
+	}
+
+	if err := s.Suspend(crt, "lost again", "support"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Withdraw(crt, certificate.REASON_KEY_COMPROMISE, "stolen", time.Time{}, "support"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reinstate(crt); err == nil {
+		t.Fatal("withdrawn certificate reinstated")
+	}
+	if code := reportCode(s.ValidationReport("uid", "did", crt.GetCertificate())); code != VALIDATION_REVOKED {
+		t.Fatalf("withdrawn certificate validated with code %q", code)
+	}
+
+	var statuses []int
+	for _, change := range crt.GetHistory() {
+		statuses = append(statuses, change.Status)
+	}
+	expected := []int{
+		certificate.STATUS_ACTIVE, certificate.STATUS_SUSPENDED, certificate.STATUS_ACTIVE,
+		certificate.STATUS_SUSPENDED, certificate.STATUS_WITHDRAWN,
+	}
+	if fmt.Sprint(statuses) != fmt.Sprint(expected) {
+		t.Fatalf("history %v, expected %v", statuses, expected)
+	}
+}
+
+func TestReinstateWhenAnotherIsActive(t *testing.T) {
+	s := NewCertificateService(memory.NewCertificateRepository(), testGenerator(t))
+	crt := issue(t, s, "uid", "did")
+	if err := s.Suspend(crt, "lost", "support"); err != nil {
+		t.Fatal(err)
+	}
+	replacement := issue(t, s, "uid", "did")
+
+	if err := s.Reinstate(crt); err == nil {
+		t.Fatal("certificate reinstated while another one is active")
+	}
+	if crt.GetStatus() != certificate.STATUS_SUSPENDED {
+		t.Fatalf("refused reinstatement changed the status to %s", certificate.StatusName(crt.GetStatus()))
+	}
+	if active := s.FetchActiveCertificateByUidAndDid("uid", "did"); active == nil || active.GetSerial() != replacement.GetSerial() {
+		t.Fatal("replacement certificate is not active")
+	}
+}
+
+func TestPossessionReport(t *testing.T) {
+	s := NewCertificateService(memory.NewCertificateRepository(), testGenerator(t))
+	crt := issue(t, s, "uid", "did")
+	key, err := signer.ParsePrivateKey([]byte(crt.GetPrivateKey()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(message []byte) []byte {
+		digest := sha256.Sum256(message)
+		signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+
+	challenge := "kq3Lr1Xw0V8uXcPzY9d7h3gq2oE3oV6pYbB1mQ2yF1s"
+	report := s.PossessionReport("uid", "did", crt.GetCertificate(), challenge, sign(PossessionMessage(challenge)))
+	if code := reportCode(report); code != "" {
+		t.Fatalf("proof of possession failed with %q", code)
+	}
+	// the bare challenge is not accepted, the signature has to cover the context
+	report = s.PossessionReport("uid", "did", crt.GetCertificate(), challenge, sign([]byte(challenge)))
+	if code := reportCode(report); code != VALIDATION_POSSESSION {
+		t.Fatalf("signature over the bare challenge validated with code %q", code)
+	}
+}
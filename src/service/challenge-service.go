@@ -0,0 +1,135 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// POSSESSION_CONTEXT is signed before the challenge so that the signature can't be reused outside the proof of possession
+const POSSESSION_CONTEXT = "nc-crtmgr proof of possession:"
+
+// PossessionMessage returns the message the client signs with the certificate key for the challenge
+func PossessionMessage(challenge string) []byte {
+	return []byte(POSSESSION_CONTEXT + challenge)
+}
+
+// challenge is an issued nonce waiting for the proof of possession
+type challenge struct {
+	uid     string
+	did     string
+	client  string
+	expires time.Time
+}
+
+// ChallengeService issues one time nonces bound to the uid and did for the proof of possession of certificate keys.
+// The number of outstanding nonces is limited per client, per uid and did and overall, the per client limit keeps
+// a single client from exhausting the overall one.
+type ChallengeService struct {
+	ttl            time.Duration
+	maxOutstanding int
+	maxPerIdentity int
+	maxPerClient   int
+
+	mutex      sync.Mutex
+	challenges map[string]challenge
+	// outstanding counts the nonces of each uid and did
+	outstanding map[string]int
+	// perClient counts the nonces requested by each client
+	perClient map[string]int
+}
+
+func NewChallengeService(ttl time.Duration, maxOutstanding int, maxPerIdentity int, maxPerClient int) *ChallengeService {
+	return &ChallengeService{
+		ttl:            ttl,
+		maxOutstanding: maxOutstanding,
+		maxPerIdentity: maxPerIdentity,
+		maxPerClient:   maxPerClient,
+		challenges:     map[string]challenge{},
+		outstanding:    map[string]int{},
+		perClient:      map[string]int{},
+	}
+}
+
+func identityKey(uid string, did string) string {
+	return uid + "\x00" + did
+}
+
+// Issue returns a new nonce for the uid and did requested by the client and the time it expires at
+func (s *ChallengeService) Issue(uid string, did string, client string) (string, time.Time, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", time.Time{}, err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(data)
+	now := time.Now()
+	expires := now.Add(s.ttl)
+	key := identityKey(uid, did)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// expired nonces are swept by the timer, the limits are checked again without them
+	if len(s.challenges) >= s.maxOutstanding || s.outstanding[key] >= s.maxPerIdentity || s.perClient[client] >= s.maxPerClient {
+		s.sweep(now)
+	}
+	if s.perClient[client] >= s.maxPerClient {
+		return "", time.Time{}, errors.New("Too many outstanding challenges for the client, try again later")
+	}
+	if len(s.challenges) >= s.maxOutstanding {
+		return "", time.Time{}, errors.New("Too many outstanding challenges, try again later")
+	}
+	if s.outstanding[key] >= s.maxPerIdentity {
+		return "", time.Time{}, errors.New("Too many outstanding challenges for the UID and DID, try again later")
+	}
+	s.challenges[nonce] = challenge{uid: uid, did: did, client: client, expires: expires}
+	s.outstanding[key]++
+	s.perClient[client]++
+	return nonce, expires, nil
+}
+
+// Consume removes the nonce and checks it was issued for the uid and did and is not expired.
+// A nonce may be presented once whatever the outcome.
+func (s *ChallengeService) Consume(nonce string, uid string, did string) error {
+	s.mutex.Lock()
+	c, ok := s.challenges[nonce]
+	if ok {
+		s.remove(nonce, c)
+	}
+	s.mutex.Unlock()
+
+	if !ok || time.Now().After(c.expires) {
+		return &ValidationError{Code: VALIDATION_CHALLENGE, Message: "Challenge is unknown or expired"}
+	}
+	if c.uid != uid || c.did != did {
+		return &ValidationError{Code: VALIDATION_CHALLENGE, Message: "Challenge was issued for another UID or DID"}
+	}
+	return nil
+}
+
+// Sweep removes the expired nonces
+func (s *ChallengeService) Sweep() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sweep(time.Now())
+}
+
+func (s *ChallengeService) sweep(now time.Time) {
+	for n, c := range s.challenges {
+		if now.After(c.expires) {
+			s.remove(n, c)
+		}
+	}
+}
+
+func (s *ChallengeService) remove(nonce string, c challenge) {
+	delete(s.challenges, nonce)
+	key := identityKey(c.uid, c.did)
+	if s.outstanding[key]--; s.outstanding[key] <= 0 {
+		delete(s.outstanding, key)
+	}
+	if s.perClient[c.client]--; s.perClient[c.client] <= 0 {
+		delete(s.perClient, c.client)
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChallengeSingleUse(t *testing.T) {
+	s := NewChallengeService(time.Minute, 10, 10, 10)
+	nonce, expires, err := s.Issue("uid", "did", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expires.Before(time.Now()) {
+		t.Fatalf("challenge expires at %s", expires)
+	}
+	if err := s.Consume(nonce, "uid", "did"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Consume(nonce, "uid", "did"); err == nil {
+		t.Fatal("challenge accepted twice")
+	}
+	if err := s.Consume("unknown", "uid", "did"); err == nil {
+		t.Fatal("unknown challenge accepted")
+	}
+}
+
+func TestChallengeForeignIdentity(t *testing.T) {
+	s := NewChallengeService(time.Minute, 10, 10, 10)
+	nonce, _, err := s.Issue("uid", "did", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Consume(nonce, "uid", "other"); err == nil {
+		t.Fatal("challenge of another did accepted")
+	}
+	// the nonce is spent by the failed attempt as well
+	if err := s.Consume(nonce, "uid", "did"); err == nil {
+		t.Fatal("challenge accepted after a failed attempt")
+	}
+}
+
+func TestChallengeExpiry(t *testing.T) {
+	s := NewChallengeService(10*time.Millisecond, 10, 1, 10)
+	nonce, _, err := s.Issue("uid", "did", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := s.Consume(nonce, "uid", "did"); err == nil {
+		t.Fatal("expired challenge accepted")
+	}
+
+	if _, _, err := s.Issue("uid", "did", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	// the expired challenge no longer counts against the limit of the uid and did
+	if _, _, err := s.Issue("uid", "did", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	s.Sweep()
+	if len(s.challenges) != 0 || len(s.outstanding) != 0 || len(s.perClient) != 0 {
+		t.Fatalf("sweep left %d challenges", len(s.challenges))
+	}
+}
+
+func TestChallengeLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests [][3]string
+	}{
+		{"per identity", [][3]string{{"uid", "did", "10.0.0.1"}, {"uid", "did", "10.0.0.2"}, {"uid", "did", "10.0.0.3"}}},
+		{"per client", [][3]string{{"a", "1", "10.0.0.1"}, {"b", "2", "10.0.0.1"}, {"c", "3", "10.0.0.1"}}},
+		{"overall", [][3]string{{"a", "1", "10.0.0.1"}, {"b", "2", "10.0.0.2"}, {"c", "3", "10.0.0.3"}, {"d", "4", "10.0.0.4"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewChallengeService(time.Minute, 3, 2, 2)
+			last := len(tt.requests) - 1
+			var nonce string
+			for i, request := range tt.requests[:last] {
+				n, _, err := s.Issue(request[0], request[1], request[2])
+				if err != nil {
+					t.Fatalf("request %d refused: %s", i, err)
+				}
+				if i == 0 {
+					nonce = n
+				}
+			}
+			request := tt.requests[last]
+			if _, _, err := s.Issue(request[0], request[1], request[2]); err == nil {
+				t.Fatal("challenge issued over the limit")
+			}
+			// consuming a challenge frees its place
+			first := tt.requests[0]
+			if err := s.Consume(nonce, first[0], first[1]); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := s.Issue(request[0], request[1], request[2]); err != nil {
+				t.Fatalf("challenge refused after one was consumed: %s", err)
+			}
+		})
+	}
+}
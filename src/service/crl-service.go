@@ -0,0 +1,117 @@
+package service
+
+import (
+	"encoding/pem"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/kuai6/nc-crtmgr/src/certificate"
+	"github.com/kuai6/nc-crtmgr/src/generator"
+)
+
+// publishedCRL is the current CRL of an issuing CA
+type publishedCRL struct {
+	crl        []byte
+	thisUpdate time.Time
+}
+
+// CRLService keeps one CRL per issuing CA built from the withdrawn and suspended certificates
+type CRLService struct {
+	certificates certificate.Repository
+	generator    generator.Generator
+	nextUpdate   time.Duration
+
+	mutex sync.RWMutex
+	crls  map[string]publishedCRL
+}
+
+func NewCRLService(repository certificate.Repository, generator generator.Generator, nextUpdate time.Duration) *CRLService {
+	return &CRLService{
+		certificates: repository,
+		generator:    generator,
+		nextUpdate:   nextUpdate,
+		crls:         make(map[string]publishedCRL),
+	}
+}
+
+// Refresh rebuilds the CRLs of all issuing CAs from the withdrawn and suspended certificates
+func (s *CRLService) Refresh() error {
+	certificates := s.certificates.FindByStatus(certificate.STATUS_WITHDRAWN)
+	certificates = append(certificates, s.certificates.FindByStatus(certificate.STATUS_SUSPENDED)...)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	// the CRL number has to grow monotonically, also across restarts
+	number := big.NewInt(now.UnixNano())
+	crls := make(map[string]publishedCRL)
+	for _, issuer := range s.generator.Issuers() {
+		var revocations []generator.Revocation
+		for _, crt := range certificates {
+			// an expired certificate stays listed until a CRL issued after its expiry has been published (RFC 5280 3.3)
+			if crt.GetValidTill().Before(s.crls[issuer].thisUpdate) {
+				continue
+			}
+			revocations = append(revocations, generator.Revocation{
+				Certificate:    crt.GetCertificate(),
+				RevokedAt:      crt.GetWithdrawalDateTime(),
+				Reason:         crt.GetRevocationReason(),
+				InvalidityDate: crt.GetInvalidityDate(),
+			})
+		}
+		crl, err := s.generator.CreateCRL(issuer, revocations, number, now, now.Add(s.nextUpdate))
+		if err != nil {
+			return err
+		}
+		crls[issuer] = publishedCRL{crl: crl, thisUpdate: now}
+	}
+	s.crls = crls
+	return nil
+}
+
+// CRL returns the DER encoded CRL of the issuer, the signing CA when the issuer is empty.
+// CRLs are rebuilt when missing or outdated.
+func (s *CRLService) CRL(issuer string) ([]byte, time.Time, error) {
+	if issuer == "" {
+		var err error
+		if issuer, err = s.generator.SigningIssuer(); err != nil {
+			return nil, time.Time{}, err
+		}
+	} else if !s.known(issuer) {
+		return nil, time.Time{}, generator.ErrUnknownIssuer
+	}
+
+	s.mutex.RLock()
+	published, ok := s.crls[issuer]
+	s.mutex.RUnlock()
+
+	if !ok || time.Now().After(published.thisUpdate.Add(s.nextUpdate)) {
+		if err := s.Refresh(); err != nil {
+			return nil, time.Time{}, err
+		}
+		s.mutex.RLock()
+		published, ok = s.crls[issuer]
+		s.mutex.RUnlock()
+	}
+	return published.crl, published.thisUpdate, nil
+}
+
+func (s *CRLService) known(issuer string) bool {
+	for _, id := range s.generator.Issuers() {
+		if id == issuer {
+			return true
+		}
+	}
+	return false
+}
+
+// CRLPem returns the PEM encoded CRL of the issuer
+func (s *CRLService) CRLPem(issuer string) ([]byte, time.Time, error) {
+	crl, thisUpdate, err := s.CRL(issuer)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl}), thisUpdate, nil
+}
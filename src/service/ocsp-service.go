@@ -0,0 +1,62 @@
+package service
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/kuai6/nc-crtmgr/src/certificate"
+	"github.com/kuai6/nc-crtmgr/src/generator"
+)
+
+// OCSPService answers OCSP requests from the certificate status in the repository
+type OCSPService struct {
+	certificates certificate.Repository
+	generator    generator.Generator
+	nextUpdate   time.Duration
+}
+
+func NewOCSPService(repository certificate.Repository, generator generator.Generator, nextUpdate time.Duration) *OCSPService {
+	return &OCSPService{
+		certificates: repository,
+		generator:    generator,
+		nextUpdate:   nextUpdate,
+	}
+}
+
+// Respond returns the signed response to the DER encoded request and the time it is valid till
+func (s *OCSPService) Respond(request []byte) ([]byte, time.Time, error) {
+	serial, issuer, err := s.generator.ParseOCSPRequest(request)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	now := time.Now()
+	response := s.Status(serial, issuer)
+	response.ThisUpdate = now
+	response.NextUpdate = now.Add(s.nextUpdate)
+
+	der, err := s.generator.CreateOCSPResponse(response)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return der, response.NextUpdate, nil
+}
+
+// Status resolves the OCSP status of the certificate issued by the CA with the issuer id. Unknown serials and
+// certificates of another issuer are reported as unknown.
+func (s *OCSPService) Status(serial *big.Int, issuer string) generator.OCSPResponse {
+	response := generator.OCSPResponse{Issuer: issuer, Serial: serial, Status: generator.OCSP_UNKNOWN}
+	crt, err := s.certificates.Find(*serial)
+	if err != nil || crt == nil || !s.generator.IssuedBy(crt.GetCertificate(), issuer) {
+		return response
+	}
+	// suspended certificates are revoked with certificateHold reason
+	if crt.GetStatus() == certificate.STATUS_WITHDRAWN || crt.GetStatus() == certificate.STATUS_SUSPENDED {
+		response.Status = generator.OCSP_REVOKED
+		response.RevokedAt = crt.GetWithdrawalDateTime()
+		response.Reason = crt.GetRevocationReason()
+		return response
+	}
+	response.Status = generator.OCSP_GOOD
+	return response
+}
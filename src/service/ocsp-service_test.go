@@ -0,0 +1,112 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/kuai6/nc-crtmgr/src/certificate"
+	"github.com/kuai6/nc-crtmgr/src/generator"
+	"github.com/kuai6/nc-crtmgr/src/memory"
+	"golang.org/x/crypto/ocsp"
+)
+
+// testCA returns a new CA certificate signed by the parent, a self signed root when the parent is nil
+func testCA(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt, key
+}
+
+func encodePEM(crt *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw})
+}
+
+// issue generates a certificate for the uid and did and stores it as active
+func issue(t *testing.T, service *CertificateService, uid string, did string) *certificate.Certificate {
+	options := generator.Options{}
+	options.SetUid(uid)
+	options.SetDid(did)
+	crt, err := service.GenerateCertificate(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt
+}
+
+func TestOCSPStatusChecksIssuer(t *testing.T) {
+	root, rootKey := testCA(t, "Test Root CA", nil, nil)
+	intermediate, intermediateKey := testCA(t, "Test Intermediate CA", root, rootKey)
+	g := &generator.CryptoTLS{DefaultTTL: 30, KeyAlgorithm: generator.KEY_ALGORITHM_ECDSA_P256}
+	if err := g.LoadRootCA(encodePEM(root), rootKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddIntermediateCA(encodePEM(intermediate), nil, intermediateKey, true); err != nil {
+		t.Fatal(err)
+	}
+
+	repository := memory.NewCertificateRepository()
+	crt := issue(t, NewCertificateService(repository, g), "uid", "did")
+	block, _ := pem.Decode([]byte(crt.GetCertificate()))
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewOCSPService(repository, g, time.Hour)
+	tests := []struct {
+		name   string
+		issuer *x509.Certificate
+		status int
+	}{
+		{"issuing CA", intermediate, ocsp.Good},
+		{"other CA", root, ocsp.Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := ocsp.CreateRequest(leaf, tt.issuer, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			der, _, err := s.Respond(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := ocsp.ParseResponse(der, tt.issuer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Status != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, response.Status)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kuai6/nc-crtmgr/src/certificate"
+	"github.com/kuai6/nc-crtmgr/src/generator"
+)
+
+// Machine readable codes of validation failures
+const (
+	VALIDATION_MALFORMED         = "malformed"
+	VALIDATION_IDENTITY_MISMATCH = "identity_mismatch"
+	VALIDATION_NOT_FOUND         = "not_found"
+	VALIDATION_UNKNOWN_SERIAL    = "unknown_serial"
+	VALIDATION_REVOKED           = "revoked"
+	VALIDATION_ON_HOLD           = "on_hold"
+	VALIDATION_NOT_ACTIVE        = "not_active"
+	VALIDATION_EXPIRED           = "expired"
+	VALIDATION_NOT_ISSUED        = "not_issued"
+	VALIDATION_CHAIN             = "chain"
+	VALIDATION_CHALLENGE         = "challenge"
+	VALIDATION_POSSESSION        = "possession"
+)
+
+// ValidationError is returned when the certificate is rejected, Code tells why
+type ValidationError struct {
+	Code    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Names of the checks in ValidationReport
+const (
+	CHECK_PARSE      = "parse"
+	CHECK_IDENTITY   = "identity"
+	CHECK_REVOCATION = "revocation"
+	CHECK_EXPIRY     = "expiry"
+	CHECK_STATUS     = "status"
+	CHECK_SIGNATURE  = "signature"
+	CHECK_POSSESSION = "possession"
+)
+
+type ValidationCheck struct {
+	Name    string
+	Passed  bool
+	Code    string
+	Message string
+}
+
+// ValidationReport describes the validated certificate and the outcome of each check
+type ValidationReport struct {
+	Certificate *generator.CertificateInfo
+	// Chain is the subjects of the verified chain from the certificate up to the root
+	Chain []string
+	// Status is the stored status name or unknown for certificates which are not stored
+	Status string
+	// At is the moment the certificate is validated for
+	At     time.Time
+	Checks []ValidationCheck
+}
+
+func (r *ValidationReport) pass(name string) {
+	r.Checks = append(r.Checks, ValidationCheck{Name: name, Passed: true})
+}
+
+func (r *ValidationReport) fail(name string, code string, message string) {
+	r.Checks = append(r.Checks, ValidationCheck{Name: name, Code: code, Message: message})
+}
+
+// Err returns the first failed check as *ValidationError or nil when all of them passed
+func (r *ValidationReport) Err() error {
+	for _, check := range r.Checks {
+		if !check.Passed {
+			return &ValidationError{Code: check.Code, Message: check.Message}
+		}
+	}
+	return nil
+}
+
+// checkIdentity compares uid and did with the identity embedded into the certificate and with the stored record
+// when there is one. Certificates without a complete embedded identity are checked against the record only.
+func checkIdentity(report *ValidationReport, info *generator.CertificateInfo, record *certificate.Certificate, uid string, did string) {
+	matches := info.Uid == "" || info.Did == "" || info.Uid == uid && info.Did == did
+	if record != nil {
+		matches = matches && record.GetUid() == uid && record.GetDid() == did
+	}
+	if !matches {
+		report.fail(CHECK_IDENTITY, VALIDATION_IDENTITY_MISMATCH, "Certificate UID or DID not match with given")
+		return
+	}
+	report.pass(CHECK_IDENTITY)
+}
+
+// checkRecord checks the revocation, expiry and status of the stored certificate at the moment
+func checkRecord(report *ValidationReport, crt *certificate.Certificate, at time.Time) {
+	status, issued := crt.StatusAt(at)
+	if !issued {
+		report.fail(CHECK_STATUS, VALIDATION_NOT_ISSUED, fmt.Sprintf("Certificate was not issued yet at %s", at.Format(time.RFC3339)))
+		return
+	}
+
+	switch status {
+	case certificate.STATUS_WITHDRAWN:
+		report.fail(CHECK_REVOCATION, VALIDATION_REVOKED, withdrawalReason(crt))
+	case certificate.STATUS_SUSPENDED:
+		if crt.GetStatus() == certificate.STATUS_SUSPENDED {
+			report.fail(CHECK_REVOCATION, VALIDATION_ON_HOLD, suspensionReason(crt))
+		} else {
+			report.fail(CHECK_REVOCATION, VALIDATION_ON_HOLD, fmt.Sprintf("Certificate was on hold at %s", at.Format(time.RFC3339)))
+		}
+	default:
+		report.pass(CHECK_REVOCATION)
+	}
+
+	if at.After(crt.GetValidTill()) {
+		report.fail(CHECK_EXPIRY, VALIDATION_EXPIRED, fmt.Sprintf("Certificate expired at %s", crt.GetValidTill().Format(time.RFC3339)))
+	} else {
+		report.pass(CHECK_EXPIRY)
+	}
+
+	if status == certificate.STATUS_NOT_ACTIVE {
+		report.fail(CHECK_STATUS, VALIDATION_NOT_ACTIVE, "Certificate is superseded or not active")
+	} else {
+		report.pass(CHECK_STATUS)
+	}
+}
+
+func withdrawalReason(crt *certificate.Certificate) string {
+	reason := fmt.Sprintf("Certificate was withdrawn at %s: %s",
+		crt.GetWithdrawalDateTime().Format(time.RFC3339), certificate.ReasonName(crt.GetRevocationReason()))
+	if crt.GetRevocationComment() != "" {
+		reason = fmt.Sprintf("%s (%s)", reason, crt.GetRevocationComment())
+	}
+	return reason
+}
+
+func suspensionReason(crt *certificate.Certificate) string {
+	reason := fmt.Sprintf("Certificate is on hold since %s", crt.GetWithdrawalDateTime().Format(time.RFC3339))
+	if crt.GetRevocationComment() != "" {
+		reason = fmt.Sprintf("%s (%s)", reason, crt.GetRevocationComment())
+	}
+	return reason
+}
@@ -0,0 +1,40 @@
+package signer
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// NewFileSigner loads an unencrypted private key from the file
+func NewFileSigner(path string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Can't read private key %s: %s", path, err))
+	}
+	key, err := ParsePrivateKey(data, nil)
+	if err == ErrEncryptedKey {
+		return nil, errors.New(fmt.Sprintf("Private key %s is encrypted, use %s backend", path, BACKEND_ENCRYPTED_FILE))
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse private key %s: %s", path, err))
+	}
+	return key, nil
+}
+
+// NewEncryptedFileSigner loads a password protected private key from the file
+func NewEncryptedFileSigner(path string, password []byte) (crypto.Signer, error) {
+	if len(password) == 0 {
+		return nil, errors.New(fmt.Sprintf("Password for private key %s is not given", path))
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Can't read private key %s: %s", path, err))
+	}
+	key, err := ParsePrivateKey(data, password)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to decrypt private key %s: %s", path, err))
+	}
+	return key, nil
+}
@@ -0,0 +1,84 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/youmark/pkcs8"
+)
+
+// writeKey writes the PEM block to a temporary file
+func writeKey(t *testing.T, block *pem.Block) string {
+	path := filepath.Join(t.TempDir(), "ca.key")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileSigner(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	s, err := NewFileSigner(writeKey(t, &pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.PublicKey.Equal(s.Public()) {
+		t.Fatal("loaded key differs")
+	}
+
+	if _, err := NewFileSigner(filepath.Join(t.TempDir(), "missing.key")); err == nil {
+		t.Fatal("missing key file was accepted")
+	}
+	if _, err := NewFileSigner(writeKey(t, &pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")})); err == nil {
+		t.Fatal("malformed key was accepted")
+	}
+}
+
+func TestEncryptedFileSigner(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	password := []byte("secret")
+
+	encrypted, err := pkcs8.MarshalPrivateKey(key, password, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, _ := x509.MarshalECPrivateKey(key)
+	legacy, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", sec1, password, x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"pkcs8":      writeKey(t, &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted}),
+		"legacy pem": writeKey(t, legacy),
+	}
+	for name, path := range files {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewEncryptedFileSigner(path, password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.PublicKey.Equal(s.Public()) {
+				t.Fatal("decrypted key differs")
+			}
+			if _, err := NewEncryptedFileSigner(path, []byte("wrong")); err == nil {
+				t.Fatal("wrong password was accepted")
+			}
+			if _, err := NewEncryptedFileSigner(path, nil); err == nil {
+				t.Fatal("missing password was accepted")
+			}
+			if _, err := NewFileSigner(path); err == nil || !strings.Contains(err.Error(), BACKEND_ENCRYPTED_FILE) {
+				t.Fatalf("file backend did not point to %s: %v", BACKEND_ENCRYPTED_FILE, err)
+			}
+		})
+	}
+}
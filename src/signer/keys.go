@@ -0,0 +1,65 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+
+	"github.com/youmark/pkcs8"
+)
+
+var ErrEncryptedKey = errors.New("Private key is encrypted, password is required")
+
+// ParsePrivateKey parses a PEM encoded PKCS#1, PKCS#8 or SEC1 private key.
+// Encrypted PKCS#8 and legacy PEM encrypted keys are decrypted with the password.
+func ParsePrivateKey(data []byte, password []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No PEM data found")
+	}
+
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		if len(password) == 0 {
+			return nil, ErrEncryptedKey
+		}
+		key, _, err := pkcs8.ParsePrivateKey(block.Bytes, password)
+		if err != nil {
+			return nil, err
+		}
+		return toSigner(key)
+	}
+
+	der := block.Bytes
+	if x509.IsEncryptedPEMBlock(block) {
+		if len(password) == 0 {
+			return nil, ErrEncryptedKey
+		}
+		var err error
+		if der, err = x509.DecryptPEMBlock(block, password); err != nil {
+			return nil, err
+		}
+	}
+	return parseDER(der)
+}
+
+func parseDER(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return toSigner(key)
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("Unknown private key format, expected PKCS#1, PKCS#8 or SEC1")
+}
+
+func toSigner(key interface{}) (crypto.Signer, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("Unsupported private key type")
+	}
+	return signer, nil
+}
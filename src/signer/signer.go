@@ -0,0 +1,14 @@
+// Package signer provides crypto.Signer backends for CA private keys.
+//
+// The file backend loads an unencrypted key, the encrypted_file backend a password
+// protected one and the socket backend delegates signing to an external process
+// (a KMS or HSM bridge) listening on a Unix socket, so the key never enters this process.
+// The none backend leaves the CA without a key, for an offline root.
+package signer
+
+const (
+	BACKEND_NONE           = "none"
+	BACKEND_FILE           = "file"
+	BACKEND_ENCRYPTED_FILE = "encrypted_file"
+	BACKEND_SOCKET         = "socket"
+)
@@ -0,0 +1,119 @@
+package signer
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// The socket protocol is one JSON request line answered with one JSON response line per connection.
+//
+//	-> {"op":"public_key"}
+//	<- {"public_key":"<base64 DER PKIX public key>"}
+//	-> {"op":"sign","hash":"SHA-256","digest":"<base64>","pss":false,"pss_salt_length":0}
+//	<- {"signature":"<base64>"}
+//
+// The hash is empty for Ed25519 keys, the digest is the whole message then.
+// Failures are answered with {"error":"<description>"}.
+type socketRequest struct {
+	Op            string `json:"op"`
+	Hash          string `json:"hash,omitempty"`
+	Digest        string `json:"digest,omitempty"`
+	PSS           bool   `json:"pss,omitempty"`
+	PSSSaltLength int    `json:"pss_salt_length,omitempty"`
+}
+
+type socketResponse struct {
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+	Error     string `json:"error"`
+}
+
+type SocketSigner struct {
+	path      string
+	timeout   time.Duration
+	publicKey crypto.PublicKey
+}
+
+// NewSocketSigner connects to the signer process and fetches its public key
+func NewSocketSigner(path string, timeout time.Duration) (*SocketSigner, error) {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	s := &SocketSigner{path: path, timeout: timeout}
+
+	response, err := s.call(socketRequest{Op: "public_key"})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to fetch public key from signer %s: %s", path, err))
+	}
+	der, err := base64.StdEncoding.DecodeString(response.PublicKey)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to decode public key from signer %s: %s", path, err))
+	}
+	if s.publicKey, err = x509.ParsePKIXPublicKey(der); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse public key from signer %s: %s", path, err))
+	}
+	return s, nil
+}
+
+func (s *SocketSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+func (s *SocketSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	request := socketRequest{
+		Op:     "sign",
+		Digest: base64.StdEncoding.EncodeToString(digest),
+	}
+	if opts != nil && opts.HashFunc() != 0 {
+		request.Hash = opts.HashFunc().String()
+	}
+	if pss, ok := opts.(*rsa.PSSOptions); ok {
+		request.PSS = true
+		request.PSSSaltLength = pss.SaltLength
+	}
+
+	response, err := s.call(request)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Signer %s failed: %s", s.path, err))
+	}
+	return base64.StdEncoding.DecodeString(response.Signature)
+}
+
+func (s *SocketSigner) call(request socketRequest) (*socketResponse, error) {
+	conn, err := net.DialTimeout("unix", s.path, s.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && !(err == io.EOF && len(line) > 0) {
+		return nil, err
+	}
+	var response socketResponse
+	if err = json.Unmarshal(line, &response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return &response, nil
+}
@@ -0,0 +1,187 @@
+package signer
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// listen serves one request line per connection on a temporary Unix socket with the reply of handle
+func listen(t *testing.T, handle func(line []byte) []byte) (string, net.Listener) {
+	path := filepath.Join(t.TempDir(), "signer.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(conn).ReadBytes('\n')
+			conn.Write(handle(line))
+			conn.Close()
+		}
+	}()
+	return path, listener
+}
+
+// fakeSigner answers the socket protocol with the key
+func fakeSigner(key crypto.Signer) func(line []byte) []byte {
+	return func(line []byte) []byte {
+		var request socketRequest
+		var response socketResponse
+		if err := json.Unmarshal(line, &request); err != nil {
+			response.Error = err.Error()
+		}
+		switch request.Op {
+		case "public_key":
+			der, _ := x509.MarshalPKIXPublicKey(key.Public())
+			response.PublicKey = base64.StdEncoding.EncodeToString(der)
+		case "sign":
+			digest, _ := base64.StdEncoding.DecodeString(request.Digest)
+			var opts crypto.SignerOpts = crypto.Hash(0)
+			for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+				if request.Hash == hash.String() {
+					opts = hash
+				}
+			}
+			if request.PSS {
+				opts = &rsa.PSSOptions{SaltLength: request.PSSSaltLength, Hash: opts.HashFunc()}
+			}
+			signature, err := key.Sign(rand.Reader, digest, opts)
+			if err != nil {
+				response.Error = err.Error()
+			}
+			response.Signature = base64.StdEncoding.EncodeToString(signature)
+		}
+		data, _ := json.Marshal(response)
+		return append(data, '\n')
+	}
+}
+
+func TestSocketSigner(t *testing.T) {
+	message := []byte("certificate to be signed")
+	digest := sha256.Sum256(message)
+
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name   string
+		key    crypto.Signer
+		sign   func(s crypto.Signer) ([]byte, error)
+		verify func(signature []byte) bool
+	}{
+		{"ecdsa", ecdsaKey,
+			func(s crypto.Signer) ([]byte, error) { return s.Sign(rand.Reader, digest[:], crypto.SHA256) },
+			func(signature []byte) bool { return ecdsa.VerifyASN1(&ecdsaKey.PublicKey, digest[:], signature) }},
+		{"ed25519", ed25519Key,
+			func(s crypto.Signer) ([]byte, error) { return s.Sign(rand.Reader, message, crypto.Hash(0)) },
+			func(signature []byte) bool {
+				return ed25519.Verify(ed25519Key.Public().(ed25519.PublicKey), message, signature)
+			}},
+		{"rsa", rsaKey,
+			func(s crypto.Signer) ([]byte, error) { return s.Sign(rand.Reader, digest[:], crypto.SHA256) },
+			func(signature []byte) bool {
+				return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], signature) == nil
+			}},
+		{"rsa pss", rsaKey,
+			func(s crypto.Signer) ([]byte, error) {
+				return s.Sign(rand.Reader, digest[:], &rsa.PSSOptions{SaltLength: 32, Hash: crypto.SHA256})
+			},
+			func(signature []byte) bool {
+				opts := &rsa.PSSOptions{SaltLength: 32}
+				return rsa.VerifyPSS(&rsaKey.PublicKey, crypto.SHA256, digest[:], signature, opts) == nil
+			}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, _ := listen(t, fakeSigner(test.key))
+			s, err := NewSocketSigner(path, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !s.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(test.key.Public()) {
+				t.Fatal("public key of the signer differs from the key")
+			}
+			signature, err := test.sign(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.verify(signature) {
+				t.Fatal("signature does not verify")
+			}
+		})
+	}
+}
+
+func TestSocketSignerErrors(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	digest := sha256.Sum256([]byte("certificate to be signed"))
+
+	t.Run("closed socket", func(t *testing.T) {
+		path, listener := listen(t, fakeSigner(key))
+		s, err := NewSocketSigner(path, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener.Close()
+		if _, err := s.Sign(rand.Reader, digest[:], crypto.SHA256); err == nil {
+			t.Fatal("signing through a closed socket succeeded")
+		}
+		if _, err := NewSocketSigner(path, 0); err == nil {
+			t.Fatal("connecting to a closed socket succeeded")
+		}
+	})
+
+	replies := map[string]string{
+		"malformed reply": "{\"signature\":\n",
+		"no reply":        "",
+		"error reply":     "{\"error\":\"key is disabled\"}\n",
+		"bad signature":   "{\"signature\":\"not base64!\"}\n",
+	}
+	for name, reply := range replies {
+		t.Run(name, func(t *testing.T) {
+			path, _ := listen(t, func(line []byte) []byte {
+				if strings.Contains(string(line), `"op":"public_key"`) {
+					return fakeSigner(key)(line)
+				}
+				return []byte(reply)
+			})
+			s, err := NewSocketSigner(path, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = s.Sign(rand.Reader, digest[:], crypto.SHA256)
+			if err == nil {
+				t.Fatal("signing succeeded")
+			}
+			if name == "error reply" && !strings.Contains(err.Error(), "key is disabled") {
+				t.Fatalf("error of the signer is not reported: %s", err)
+			}
+		})
+	}
+
+	t.Run("malformed public key", func(t *testing.T) {
+		path, _ := listen(t, func(line []byte) []byte { return []byte("{\"public_key\":\"AAAA\"}\n") })
+		if _, err := NewSocketSigner(path, 0); err == nil {
+			t.Fatal("signer with a malformed public key was accepted")
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package sqldb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	DRIVER_SQLITE   = "sqlite"
+	DRIVER_POSTGRES = "postgres"
+)
+
+// dialect holds the differences between the supported databases
+type dialect struct {
+	// driver is the database/sql driver name
+	driver string
+	// timestamp is the column type of dates, the sqlite driver parses only some type names back to time.Time
+	timestamp string
+	// numbered placeholders $1, $2 instead of ?
+	numbered bool
+	// prepare runs before the migration transactions
+	prepare []string
+	// lock serializes migrations of concurrently starting instances till the end of the transaction,
+	// the schema_migrations table exists afterwards
+	lock []string
+}
+
+// createSchemaMigrations creates the table holding the applied schema versions
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY)`
+
+// MIGRATION_LOCK_ID is the postgres advisory lock taken while migrating
+const MIGRATION_LOCK_ID = 7201536
+
+var dialects = map[string]dialect{
+	// the write takes the database lock which a deferred transaction would take only at the first migration,
+	// creating the table inside could not wait for another writer
+	DRIVER_SQLITE: {
+		driver:    "sqlite3",
+		timestamp: "TIMESTAMP",
+		prepare:   []string{createSchemaMigrations},
+		lock:      []string{`DELETE FROM schema_migrations WHERE version < 0`},
+	},
+	// concurrent CREATE TABLE IF NOT EXISTS may fail on postgres, the advisory lock is taken before
+	DRIVER_POSTGRES: {
+		driver:    "postgres",
+		timestamp: "TIMESTAMPTZ",
+		numbered:  true,
+		lock:      []string{fmt.Sprintf(`SELECT pg_advisory_xact_lock(%d)`, MIGRATION_LOCK_ID), createSchemaMigrations},
+	},
+}
+
+// Open connects to the database with the driver name used in the config
+func Open(driver string, dsn string) (*sql.DB, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unsupported SQL driver %s", driver))
+	}
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == DRIVER_SQLITE {
+		// SQLite allows a single writer, also each connection to :memory: is a separate database
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// rebind replaces ? placeholders with the ones of the dialect
+func (d dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
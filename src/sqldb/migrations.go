@@ -0,0 +1,78 @@
+package sqldb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// migrations are applied in order once, the index of the migration is its schema version.
+// Never change an applied migration, append a new one instead. {timestamp} is the column type of dates.
+var migrations = []string{
+	`CREATE TABLE certificate (
+		serial             VARCHAR(64) NOT NULL PRIMARY KEY,
+		uid                VARCHAR(255) NOT NULL,
+		did                VARCHAR(255) NOT NULL,
+		privatekey         TEXT NOT NULL,
+		certificate        TEXT NOT NULL,
+		creationdatetime   {timestamp} NOT NULL,
+		validtill          {timestamp} NOT NULL,
+		withdrawaldatetime {timestamp} NOT NULL,
+		status             INTEGER NOT NULL,
+		revocationreason   INTEGER NOT NULL,
+		revocationcomment  TEXT NOT NULL,
+		invaliditydate     {timestamp} NOT NULL,
+		revokedby          VARCHAR(255) NOT NULL,
+		history            TEXT NOT NULL
+	)`,
+	`CREATE INDEX certificate_uid_did_status ON certificate (uid, did, status)`,
+	`CREATE INDEX certificate_did ON certificate (did)`,
+	`CREATE INDEX certificate_status ON certificate (status)`,
+	`CREATE INDEX certificate_validtill ON certificate (validtill)`,
+}
+
+// migrate brings the schema up to date, each migration runs in its own transaction.
+// The version is read under the migration lock so instances starting together apply every migration once.
+func migrate(db *sql.DB, d dialect) error {
+	for _, statement := range d.prepare {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	for {
+		applied, err := migrateNext(db, d)
+		if err != nil || !applied {
+			return err
+		}
+	}
+}
+
+// migrateNext applies the migration following the current version, it reports false when the schema is up to date
+func migrateNext(db *sql.DB, d dialect) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range d.lock {
+		if _, err := tx.Exec(statement); err != nil {
+			return false, errors.New(fmt.Sprintf("Failed to lock schema migrations: %s", err))
+		}
+	}
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return false, err
+	}
+	if version >= len(migrations) {
+		return false, tx.Commit()
+	}
+	if _, err := tx.Exec(strings.Replace(migrations[version], "{timestamp}", d.timestamp, -1)); err != nil {
+		return false, errors.New(fmt.Sprintf("Migration %d failed: %s", version+1, err))
+	}
+	if _, err := tx.Exec(d.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), version+1); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
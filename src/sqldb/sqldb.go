@@ -0,0 +1,156 @@
+// Package sqldb provides a certificate.Repository on SQLite or PostgreSQL through database/sql.
+// The schema is created and upgraded by migrations when the repository is built.
+package sqldb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/kuai6/nc-crtmgr/src/certificate"
+)
+
+const columns = `serial, uid, did, privatekey, certificate, creationdatetime, validtill, withdrawaldatetime, status,
+	revocationreason, revocationcomment, invaliditydate, revokedby, history`
+
+type CertificateRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// NewCertificateRepository migrates the schema of the database opened with Open for the driver
+func NewCertificateRepository(db *sql.DB, driver string) (certificate.Repository, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unsupported SQL driver %s", driver))
+	}
+	if err := migrate(db, d); err != nil {
+		return nil, err
+	}
+	return &CertificateRepository{db: db, dialect: d}, nil
+}
+
+func (r *CertificateRepository) Store(certificate *certificate.Certificate) error {
+	history, err := json.Marshal(certificate.GetHistory())
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(r.dialect.rebind(`INSERT INTO certificate (`+columns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (serial) DO UPDATE SET
+			uid = excluded.uid,
+			did = excluded.did,
+			privatekey = excluded.privatekey,
+			certificate = excluded.certificate,
+			creationdatetime = excluded.creationdatetime,
+			validtill = excluded.validtill,
+			withdrawaldatetime = excluded.withdrawaldatetime,
+			status = excluded.status,
+			revocationreason = excluded.revocationreason,
+			revocationcomment = excluded.revocationcomment,
+			invaliditydate = excluded.invaliditydate,
+			revokedby = excluded.revokedby,
+			history = excluded.history`),
+		certificate.GetSerial(),
+		certificate.GetUid(),
+		certificate.GetDid(),
+		certificate.PrivateKey,
+		certificate.Certificate,
+		certificate.GetCreationDateTime().UTC(),
+		certificate.GetValidTill().UTC(),
+		certificate.GetWithdrawalDateTime().UTC(),
+		certificate.GetStatus(),
+		certificate.GetRevocationReason(),
+		certificate.GetRevocationComment(),
+		certificate.GetInvalidityDate().UTC(),
+		certificate.GetRevokedBy(),
+		string(history),
+	)
+	return err
+}
+
+func (r *CertificateRepository) Find(serial big.Int) (*certificate.Certificate, error) {
+	// serials are stored as decimal strings
+	crts, err := r.query(`WHERE serial = ?`, serial.String())
+	if err != nil {
+		return nil, err
+	}
+	if len(crts) == 0 {
+		return nil, errors.New(fmt.Sprintf("certificate with serial %s not found", serial.String()))
+	}
+	return crts[0], nil
+}
+
+func (r *CertificateRepository) FindAll() []*certificate.Certificate {
+	return r.find(``)
+}
+
+func (r *CertificateRepository) FindExpired() []*certificate.Certificate {
+	return r.find(`WHERE validtill <= ?`, time.Now().UTC())
+}
+
+func (r *CertificateRepository) FindByStatus(status int) []*certificate.Certificate {
+	return r.find(`WHERE status = ?`, status)
+}
+
+func (r *CertificateRepository) FindByUid(uid string) []*certificate.Certificate {
+	return r.find(`WHERE uid = ?`, uid)
+}
+
+func (r *CertificateRepository) FindByDid(did string) []*certificate.Certificate {
+	return r.find(`WHERE did = ?`, did)
+}
+
+func (r *CertificateRepository) FindByGidAndDidAndStatus(uid string, did string, status int) []*certificate.Certificate {
+	return r.find(`WHERE uid = ? AND did = ? AND status = ?`, uid, did, status)
+}
+
+// find returns the matching certificates or an empty list on failure like the other backends
+func (r *CertificateRepository) find(where string, args ...interface{}) []*certificate.Certificate {
+	crts, err := r.query(where, args...)
+	if err != nil {
+		return []*certificate.Certificate{}
+	}
+	return crts
+}
+
+func (r *CertificateRepository) query(where string, args ...interface{}) ([]*certificate.Certificate, error) {
+	rows, err := r.db.Query(r.dialect.rebind(`SELECT `+columns+` FROM certificate `+where+` ORDER BY creationdatetime, serial`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*certificate.Certificate{}
+	for rows.Next() {
+		crt := new(certificate.Certificate)
+		var history string
+		err := rows.Scan(
+			&crt.Serial,
+			&crt.Uid,
+			&crt.Did,
+			&crt.PrivateKey,
+			&crt.Certificate,
+			&crt.CreationDateTime,
+			&crt.ValidTill,
+			&crt.WithdrawalDateTime,
+			&crt.Status,
+			&crt.RevocationReason,
+			&crt.RevocationComment,
+			&crt.InvalidityDate,
+			&crt.RevokedBy,
+			&history,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(history), &crt.History); err != nil {
+			return nil, err
+		}
+		result = append(result, crt)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,115 @@
+package sqldb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kuai6/nc-crtmgr/src/certificate"
+	"github.com/kuai6/nc-crtmgr/src/certificate/repositorytest"
+)
+
+// sqlitePath returns the path of a new database file removed after the test
+func sqlitePath(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "certificates.db")
+}
+
+// postgresDSN returns SQLDB_POSTGRES_DSN after dropping the tables of the database, the test is
+// skipped without it. Point it at a database used for nothing else.
+func postgresDSN(t *testing.T) string {
+	dsn := os.Getenv("SQLDB_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("SQLDB_POSTGRES_DSN is not set")
+	}
+	db, err := Open(DRIVER_POSTGRES, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`DROP TABLE IF EXISTS certificate, schema_migrations`); err != nil {
+		t.Fatal(err)
+	}
+	return dsn
+}
+
+func repositoryFactory(driver string, dsn func(t *testing.T) string) repositorytest.Factory {
+	return func(t *testing.T) certificate.Repository {
+		db, err := Open(driver, dsn(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		repository, err := NewCertificateRepository(db, driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repository
+	}
+}
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, repositoryFactory(DRIVER_SQLITE, sqlitePath))
+}
+
+func TestPostgresRepository(t *testing.T) {
+	postgresDSN(t)
+	repositorytest.Run(t, repositoryFactory(DRIVER_POSTGRES, postgresDSN))
+}
+
+func TestMigrateTwice(t *testing.T) {
+	db, err := Open(DRIVER_SQLITE, sqlitePath(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := NewCertificateRepository(db, DRIVER_SQLITE); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var version int
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Fatalf("expected schema version %d, got %d", len(migrations), version)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	if got := dialects[DRIVER_POSTGRES].rebind("uid = ? AND did = ?"); got != "uid = $1 AND did = $2" {
+		t.Fatalf("unexpected postgres query %q", got)
+	}
+	if got := dialects[DRIVER_SQLITE].rebind("uid = ?"); got != "uid = ?" {
+		t.Fatalf("unexpected sqlite query %q", got)
+	}
+}
+
+func TestMigrateConcurrently(t *testing.T) {
+	migrateConcurrently(t, DRIVER_SQLITE, sqlitePath(t))
+}
+
+func TestPostgresMigrateConcurrently(t *testing.T) {
+	migrateConcurrently(t, DRIVER_POSTGRES, postgresDSN(t))
+}
+
+func migrateConcurrently(t *testing.T, driver string, dsn string) {
+	errs := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			db, err := Open(driver, dsn)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer db.Close()
+			_, err = NewCertificateRepository(db, driver)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}